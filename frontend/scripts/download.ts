@@ -1,4 +1,4 @@
-import {IModule, IController, IQService, IHttpService, IPromise} from "angular";
+import {IModule, IController, IQService, IHttpService, IPromise, IRootScopeService} from "angular";
 import {Project} from "./project";
 import IScope = angular.IScope;
 
@@ -9,9 +9,11 @@ export function buildDownloadComponent(mod: IModule) {
 export class DownloadDialogController implements IController {
   private project: Project;
   private destroyed: boolean = false;
+  private jobId: string = null;
 
   public status: IExportStatus;
   public progress: number = -1;
+  public error: string = null;
 
   constructor(private $mdDialog: ng.material.IDialogService,
               private downloadService: DownloadService,
@@ -21,9 +23,15 @@ export class DownloadDialogController implements IController {
     this.project = locals["project"] as Project;
 
     this.downloadService
-      .poll(this.project, (progress: number): boolean => this.updateProgress(progress))
+      .poll(this.project,
+        (progress: number): boolean => this.updateProgress(progress),
+        (id: string) => this.jobId = id)
       .then(status => this.status = status)
-      .catch(error => console.log("polling stopped with an error: " + error));
+      .catch(error => {
+        console.log("polling stopped with an error: " + error);
+        if (typeof error === "string")
+          this.error = error;
+      });
 
     this.$scope.$on("$destroy", () => this.$onDestroy());
   }
@@ -31,6 +39,11 @@ export class DownloadDialogController implements IController {
   public $onDestroy() {
     console.log("DownloadDialogController was destroyed");
     this.destroyed = true;
+
+    // nobody is waiting for the video anymore
+    if (this.jobId != null && this.status == null && this.error == null) {
+      this.downloadService.cancel(this.jobId);
+    }
   }
 
   public close() {
@@ -50,29 +63,34 @@ export class DownloadDialogController implements IController {
   }
 
   public get finished(): boolean {
-    return this.status != null && this.status.finished;
+    return this.status != null && this.status.status === "done";
   }
 
   public get videoUrl(): string {
-    return this.status != null ? `../video/${this.status.id}/video.mp4` : "#";
+    return this.status != null ? "../" + (this.status.video || `video/${this.status.id}/video.mp4`) : "#";
   }
 }
 
 
 interface IExportStatus {
   id: string
+  status: "queued" | "running" | "failed" | "done" | "expired" | "cancelled"
+  stage?: string
+  error?: string
   progress: number
   finished: boolean
+  video?: string
 }
 
 class DownloadService {
-  constructor(private $q: IQService, private $http: IHttpService) {
+  constructor(private $q: IQService, private $http: IHttpService, private $rootScope: IRootScopeService) {
   }
 
-  private doExport(project: Project): IPromise<IExportStatus> {
+  private doExport(project: Project, startedCallback: (id: string) => void): IPromise<IExportStatus> {
     return this.$http.post("../api/export", project.currentState).then(resp => {
       const id = resp.data && resp.data["jobId"];
       if (id != null) {
+        startedCallback(id);
         return this.fetchStatus(id);
 
       } else {
@@ -86,7 +104,13 @@ class DownloadService {
     return this.$http.get("../api/export/" + id).then(resp => resp.data as IExportStatus);
   }
 
-  public poll(project: Project, progressCallback: (number?: number) => boolean): IPromise<IExportStatus> {
+  public cancel(id: string): IPromise<any> {
+    return this.$http.delete("../api/export/" + id);
+  }
+
+  public poll(project: Project,
+              progressCallback: (number?: number) => boolean,
+              startedCallback: (id: string) => void): IPromise<IExportStatus> {
     const fetchStatusWithRetry = (id: string): IPromise<IExportStatus> => {
       if (!progressCallback(null)) {
         console.log("Stop polling for %s now", id);
@@ -99,8 +123,8 @@ class DownloadService {
     };
 
     const handleStatus = (status: IExportStatus): IPromise<IExportStatus> => {
-      if (status.finished)
-        return this.$q.resolve(status);
+      if (status.status !== "queued" && status.status !== "running")
+        return this.finalStatus(status);
 
       if (!progressCallback(status.progress)) {
         console.log("Stop polling for %s now", status.id);
@@ -110,7 +134,61 @@ class DownloadService {
       return delay(this.$q, 1000).then(() => fetchStatusWithRetry(status.id)).then(handleStatus);
     };
 
-    return this.doExport(project).then(handleStatus);
+    return this.doExport(project, startedCallback).then(status => {
+      // prefer live updates over polling
+      if (typeof EventSource !== "undefined")
+        return this.stream(status.id, progressCallback).then(status => this.finalStatus(status));
+
+      return handleStatus(status);
+    });
+  }
+
+  /**
+   * Listens for status events of the job until it is no longer queued or running.
+   */
+  private stream(id: string, progressCallback: (number?: number) => boolean): IPromise<IExportStatus> {
+    return this.$q<IExportStatus>((resolve, reject) => {
+      const source = new EventSource("../api/export/" + id + "/events");
+
+      const stop = (reason: string) => {
+        console.log("Stop listening for %s now", id);
+        source.close();
+        reject(reason);
+      };
+
+      source.addEventListener("status", (event: MessageEvent) => {
+        const status = JSON.parse(event.data) as IExportStatus;
+        if (status.status !== "queued" && status.status !== "running") {
+          source.close();
+          resolve(status);
+          return;
+        }
+
+        this.$rootScope.$applyAsync(() => {
+          if (!progressCallback(status.progress))
+            stop("polling stopped");
+        });
+      });
+
+      // the browser reconnects by itself, we only need to check if anyone is still interested.
+      source.onerror = () => {
+        if (!progressCallback(null))
+          stop("polling stopped");
+      };
+    });
+  }
+
+  private finalStatus(status: IExportStatus): IPromise<IExportStatus> {
+    if (status.status === "failed")
+      return this.$q.reject(`Fehler beim Verarbeiten des Videos (${status.stage}): ${status.error}`);
+
+    if (status.status === "expired")
+      return this.$q.reject("Das Video ist nicht mehr verfügbar.");
+
+    if (status.status === "cancelled")
+      return this.$q.reject("Das Verarbeiten des Videos wurde abgebrochen.");
+
+    return this.$q.resolve(status);
   }
 }
 
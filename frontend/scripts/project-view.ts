@@ -59,6 +59,13 @@ class ProjectViewController implements IController {
     const videoUrl = this.project.video;
     console.log("Loading video ", videoUrl);
 
+    // the server might already have told us everything we need to know
+    const info = this.project.videoInfo;
+    if (info != null && info.duration > 0 && info.width > 0 && info.height > 0) {
+      this.video = {aspect: info.width / info.height, duration: info.duration, url: videoUrl};
+      return;
+    }
+
     // create a video element to load the video off-screen
     const element = document.createElement("video");
     element.src = videoUrl;
@@ -13,8 +13,23 @@ export function buildProjectComponent(mod: IModule) {
   })
 }
 
-interface IResolveVideoResponse {
+interface IResolveVideoResponse extends IVideoInfo {
   url: string;
+  resolver: string;
+  title?: string;
+}
+
+/**
+ * What the server knows about the video of a project, if it was resolved there.
+ */
+export interface IVideoInfo {
+  thumbnail?: string;
+  width?: number;
+  height?: number;
+  duration?: number;
+  frameRate?: number;
+  audio: boolean;
+  size?: number;
 }
 
 class ProjectOverview implements IController {
@@ -40,13 +55,17 @@ class ProjectOverview implements IController {
   }
 
   public createNewProject(): void {
-    const match = /[0-9]+$/.exec(this.newProjectVideoUrl);
+    const input = this.newProjectVideoUrl.trim();
+
+    // a plain number is the id of a pr0gramm post
+    const resolveUrl = /^[0-9]+$/.test(input)
+      ? "../resolve/" + input
+      : "../resolve?url=" + encodeURIComponent(input);
 
     this.$http
-      .get("../resolve/" + match[0])
+      .get(resolveUrl)
       .then(result => result.data as IResolveVideoResponse)
-      .then(response => response.url)
-      .then(url => this.handleResolvedUrl(url))
+      .then(response => this.handleResolvedVideo(response))
       .catch(err => this.showErrorDialog("Projekt konnte nicht angelegt werden."))
   }
 
@@ -55,16 +74,25 @@ class ProjectOverview implements IController {
     this.newProjectVideoUrl = "";
   }
 
-  private handleResolvedUrl(url: string) {
-    if (!/mp4$/.test(url)) {
+  private handleResolvedVideo(video: IResolveVideoResponse) {
+    if (video.resolver === "pr0gramm" && !/mp4$/.test(video.url)) {
       this.showErrorDialog("Der angegebene Post ist kein Video.");
       return;
     }
 
     const project: Project = new Project({
       id: "pr" + Date.now(),
-      title: this.newProjectTitle,
-      video: url,
+      title: this.newProjectTitle || video.title || "",
+      video: video.url,
+      videoInfo: {
+        thumbnail: video.thumbnail,
+        width: video.width,
+        height: video.height,
+        duration: video.duration,
+        frameRate: video.frameRate,
+        audio: video.audio,
+        size: video.size,
+      },
       silent: false,
       subtitles: [],
     });
@@ -96,6 +124,7 @@ export interface IProjectState {
   id: string;
   title: string;
   video: string;
+  videoInfo?: IVideoInfo;
   silent: boolean;
   subtitles: ISubtitleState[];
 }
@@ -191,6 +220,10 @@ export class Project {
     return this.baseState.video;
   }
 
+  get videoInfo(): IVideoInfo {
+    return this.baseState.videoInfo;
+  }
+
   get baseState(): IProjectState {
     return angular.copy(this._baseState);
   }
@@ -0,0 +1,104 @@
+package job
+
+import (
+  "fmt"
+  "strings"
+)
+
+// The audio the encoder reads in addition to the video input.
+type audioInput struct {
+  // arguments to pass to ffmpeg to open the inputs
+  Args       []string
+
+  // number of inputs opened by Args
+  InputCount int
+
+  // filters producing the stream to map, if any
+  Filter     string
+
+  // the stream to map, either a stream of an input or a label of the filter
+  Map        string
+
+  // codec of the mapped stream if it is an input stream, empty if it is filtered
+  Codec      string
+}
+
+// Prepares the audio of the exported video, using inputs from firstInput on. The
+// original stream is the audio of the original video, nil if it is not used.
+// The track is the audio stream of the uploaded track, nil if there is none.
+// Returns nil if the exported video has no audio at all.
+func (job *Job) prepareAudio(original, track *Stream, firstInput int) *audioInput {
+  project := job.Project
+  options := project.Audio
+
+  input := &audioInput{}
+  nextInput := firstInput
+
+  var filters []string
+
+  // the main audio, replaced by the uploaded track if there is one
+  var voice, codec string
+  if track != nil {
+    // the track already fits the exported video and is neither trimmed nor sped up
+    input.Args = append(input.Args, untrustedInput("track")...)
+    voice = fmt.Sprintf("[%d:a:0]", nextInput)
+    codec = track.Codec
+    nextInput++
+
+  } else if original != nil {
+    input.Args = append(input.Args, trimArgs(project)...)
+    input.Args = append(input.Args, untrustedInput("original.mp4")...)
+    voice = fmt.Sprintf("[%d:%d]", nextInput, original.Index)
+    codec = original.Codec
+    nextInput++
+
+    if speed := project.PlaybackSpeed(); speed != 1 {
+      filters = append(filters, voice + tempoFilter(speed) + "[voice]")
+      voice = "[voice]"
+    }
+  }
+
+  if options.Music != "" {
+    // repeat the music until the video ends
+    input.Args = append(input.Args, "-stream_loop", "-1")
+    input.Args = append(input.Args, untrustedInput("music")...)
+    filters = append(filters, fmt.Sprintf("[%d:a:0]volume=%s[music]", nextInput, formatFloat(options.musicVolume())))
+    nextInput++
+
+    if voice == "" {
+      voice = "[music]"
+    } else {
+      // lower the music while there is something to hear in the main audio. amix
+      // halves the volume of both inputs, so we turn it up again afterwards.
+      filters = append(filters,
+        voice + "asplit[voice0][voice1]",
+        "[music][voice0]sidechaincompress=threshold=0.02:ratio=8:attack=20:release=500[ducked]",
+        "[voice1][ducked]amix=inputs=2:duration=first:dropout_transition=0,volume=2[mixed]")
+
+      voice = "[mixed]"
+    }
+  }
+
+  if voice == "" {
+    return nil
+  }
+
+  if options.Normalize {
+    // loudnorm upsamples to 192kHz, so go back to a common sample rate
+    filters = append(filters, voice + "loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000[normalized]")
+    voice = "[normalized]"
+  }
+
+  input.InputCount = nextInput - firstInput
+
+  if len(filters) == 0 {
+    // map the stream directly, so the encoder can copy it
+    input.Map = strings.Trim(voice, "[]")
+    input.Codec = codec
+  } else {
+    input.Filter = strings.Join(filters, ";\n")
+    input.Map = voice
+  }
+
+  return input
+}
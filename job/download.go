@@ -0,0 +1,464 @@
+package job
+
+import (
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "hash"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/Sirupsen/logrus"
+  "github.com/pkg/errors"
+)
+
+// Cache for downloaded videos shared by all jobs. Downloads are not cached if nil.
+var Downloads *DownloadCache
+
+// Downloads the url into the target file, using the download cache if there is one.
+// Only urls allowed by the download policy are downloaded.
+func downloadToFile(ctx context.Context, url string, target string, progress ProgressUpdater) error {
+  if Limits.Timeout > 0 {
+    var cancel context.CancelFunc
+    ctx, cancel = context.WithTimeout(ctx, Limits.Timeout)
+    defer cancel()
+  }
+
+  if err := Limits.CheckUrl(ctx, url); err != nil {
+    return err
+  }
+
+  if Downloads != nil {
+    return Downloads.Download(ctx, url, target, progress)
+  }
+
+  resp, err := fetch(ctx, url, nil)
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  return writeResponse(resp, target, nil, progress)
+}
+
+// Number of bytes read from the start of a remote video to probe it.
+const probeSize = 4 * 1024 * 1024
+
+// Reads the video information from the start of a remote video. The bytes are
+// fetched using the download policy and piped into ffprobe, so ffprobe never
+// connects to anything by itself. If the start is not enough, the whole
+// video is downloaded within the size limit of the policy.
+func ReadRemoteVideoInfo(ctx context.Context, rawUrl string) (*VideoInfo, error) {
+  if err := Limits.CheckUrl(ctx, rawUrl); err != nil {
+    return nil, err
+  }
+
+  header := http.Header{}
+  header.Set("Range", fmt.Sprintf("bytes=0-%d", probeSize - 1))
+
+  resp, err := fetch(ctx, rawUrl, header)
+  if err != nil {
+    return nil, err
+  }
+
+  defer resp.Body.Close()
+
+  info, err := readVideoInfo(ctx, io.LimitReader(resp.Body, probeSize),
+    "-hide_banner", "-loglevel", "error", "-print_format", "json", "-show_streams", "-show_format",
+    "-protocol_whitelist", "pipe", "-format_whitelist", strings.Join(allowedFormats, ","), "-i", "pipe:0")
+
+  size := contentSize(resp)
+  resp.Body.Close()
+
+  if err != nil {
+    // mp4 files without faststart keep their index at the end of the file
+    if size < 0 || size > probeSize {
+      return readDownloadedVideoInfo(ctx, rawUrl)
+    }
+
+    return nil, err
+  }
+
+  // ffprobe can not know the size of the whole file, but the server does
+  info.Format.Size = ""
+  if size > 0 {
+    info.Format.Size = strconv.FormatInt(size, 10)
+  }
+
+  return info, nil
+}
+
+// Downloads the whole remote video into a temporary file to read its video information.
+// The download goes through the download cache, so an export can use it later on.
+func readDownloadedVideoInfo(ctx context.Context, rawUrl string) (*VideoInfo, error) {
+  fp, err := ioutil.TempFile("", "probe-")
+  if err != nil {
+    return nil, err
+  }
+
+  fp.Close()
+  defer os.Remove(fp.Name())
+
+  if err := downloadToFile(ctx, rawUrl, fp.Name(), nil); err != nil {
+    return nil, err
+  }
+
+  return ReadVideoInfo(ctx, fp.Name())
+}
+
+// Returns the size of the whole file, even if the response only contains a range of it.
+func contentSize(resp *http.Response) int64 {
+  if resp.StatusCode != http.StatusPartialContent {
+    return resp.ContentLength
+  }
+
+  // Content-Range: bytes 0-1023/4096
+  contentRange := resp.Header.Get("Content-Range")
+  if idx := strings.LastIndex(contentRange, "/"); idx >= 0 {
+    if size, err := strconv.ParseInt(contentRange[idx + 1:], 10, 64); err == nil {
+      return size
+    }
+  }
+
+  return -1
+}
+
+// Sends a GET request with the given additional headers. Fails if the
+// response is neither successful nor tells us that nothing was modified.
+func fetch(ctx context.Context, rawUrl string, header http.Header) (*http.Response, error) {
+  req, err := http.NewRequest("GET", rawUrl, nil)
+  if err != nil {
+    return nil, err
+  }
+
+  for key, values := range header {
+    req.Header[key] = values
+  }
+
+  resp, err := Limits.Client().Do(req.WithContext(ctx))
+  if err != nil {
+    // report a rejected address as it is, not wrapped into an url error
+    if urlErr, ok := err.(*url.Error); ok {
+      if rejected, ok := urlErr.Err.(*RejectedError); ok {
+        return nil, rejected
+      }
+    }
+
+    if ctx.Err() == context.DeadlineExceeded {
+      return nil, fmt.Errorf("Download took longer than %s", Limits.Timeout)
+    }
+
+    return nil, err
+  }
+
+  if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified && resp.StatusCode != http.StatusPartialContent {
+    resp.Body.Close()
+    return nil, fmt.Errorf("Server responded with status %d", resp.StatusCode)
+  }
+
+  if Limits.MaxSize > 0 && resp.ContentLength > Limits.MaxSize {
+    resp.Body.Close()
+    return nil, rejectf("the file is larger than %d MB", Limits.MaxSize / 1024 / 1024)
+  }
+
+  return resp, nil
+}
+
+// Writes the body of the response into the target file, and into the
+// hash if there is one. Reports the progress if the length is known.
+func writeResponse(resp *http.Response, target string, hash hash.Hash, progress ProgressUpdater) error {
+  fp, err := createFile(target)
+  if err != nil {
+    return err
+  }
+
+  defer fp.Close()
+
+  var w io.Writer = fp
+  if hash != nil {
+    w = io.MultiWriter(fp, hash)
+  }
+
+  body := Limits.limitBody(resp.Body)
+
+  var buffer [16 * 1024]byte
+
+  written := 0
+  for {
+    // read the buffer
+    n, err := body.Read(buffer[:])
+
+    // write the data we've read, even if the read also returned an error
+    if n > 0 {
+      if _, err := w.Write(buffer[:n]); err != nil {
+        return err
+      }
+
+      if resp.ContentLength > 0 && progress != nil {
+        written += n
+        progress(written, int(resp.ContentLength))
+      }
+    }
+
+    if err == io.EOF {
+      break
+    }
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return fp.Close()
+}
+
+// Keeps downloaded files in a directory, so exporting the same video again
+// does not download it again. The files are stored by the hash of their
+// content, so urls serving the same video share a single file. Cached files
+// are revalidated with the server using their ETag or Last-Modified header.
+type DownloadCache struct {
+  directory string
+
+  // the least recently used files are deleted until all of them fit
+  // into this number of bytes. Zero means no limit.
+  maxSize   int64
+
+  lock      sync.Mutex
+}
+
+// What we know about a downloaded url.
+type downloadEntry struct {
+  Url          string    `json:"url"`
+  Hash         string    `json:"hash"`
+  ETag         string    `json:"etag"`
+  LastModified string    `json:"lastModified"`
+  Used         time.Time `json:"used"`
+}
+
+func NewDownloadCache(directory string, maxSize int64) (*DownloadCache, error) {
+  if err := os.MkdirAll(filepath.Join(directory, "urls"), 0755); err != nil {
+    return nil, errors.WithMessage(err, "Could not create download cache directory")
+  }
+
+  if err := os.MkdirAll(filepath.Join(directory, "files"), 0755); err != nil {
+    return nil, errors.WithMessage(err, "Could not create download cache directory")
+  }
+
+  return &DownloadCache{directory: directory, maxSize: maxSize}, nil
+}
+
+// Puts the content of the url into the target file. Uses the cached file
+// if the server tells us that it was not modified since we downloaded it.
+func (cache *DownloadCache) Download(ctx context.Context, url string, target string, progress ProgressUpdater) error {
+  log := logrus.WithField("url", url)
+
+  entry, cached := cache.entry(url)
+
+  header := http.Header{}
+  if cached {
+    if entry.ETag != "" {
+      header.Set("If-None-Match", entry.ETag)
+    }
+
+    if entry.LastModified != "" {
+      header.Set("If-Modified-Since", entry.LastModified)
+    }
+  }
+
+  resp, err := fetch(ctx, url, header)
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode == http.StatusNotModified {
+    if !cached {
+      return errors.New("Server responded with status 304 to an unconditional request")
+    }
+
+    log.Info("Using cached download")
+    if err := cache.use(entry, target); err != nil {
+      return err
+    }
+
+    if progress != nil {
+      progress(1, 1)
+    }
+
+    return nil
+  }
+
+  entry = downloadEntry{
+    Url:          url,
+    ETag:         resp.Header.Get("ETag"),
+    LastModified: resp.Header.Get("Last-Modified"),
+  }
+
+  // we could never revalidate this file, so there is no use in caching it
+  if entry.ETag == "" && entry.LastModified == "" {
+    return writeResponse(resp, target, nil, progress)
+  }
+
+  hash := sha256.New()
+  if err := writeResponse(resp, target, hash, progress); err != nil {
+    return err
+  }
+
+  entry.Hash = hex.EncodeToString(hash.Sum(nil))
+  if err := cache.store(entry, target); err != nil {
+    // the download itself worked, so the job can continue
+    log.Warn("Could not cache download: ", err)
+  }
+
+  return nil
+}
+
+// Reads the entry of the url, if its file still exists.
+func (cache *DownloadCache) entry(url string) (downloadEntry, bool) {
+  cache.lock.Lock()
+  defer cache.lock.Unlock()
+
+  var entry downloadEntry
+
+  content, err := ioutil.ReadFile(cache.entryPath(url))
+  if err != nil || json.Unmarshal(content, &entry) != nil || entry.Url != url {
+    return downloadEntry{}, false
+  }
+
+  if _, err := os.Stat(cache.filePath(entry.Hash)); err != nil {
+    return downloadEntry{}, false
+  }
+
+  return entry, true
+}
+
+// Copies the cached file of the entry into the target file and marks it as used.
+func (cache *DownloadCache) use(entry downloadEntry, target string) error {
+  cache.lock.Lock()
+  defer cache.lock.Unlock()
+
+  if err := linkOrCopy(cache.filePath(entry.Hash), target); err != nil {
+    return errors.WithMessage(err, "Could not copy cached download")
+  }
+
+  entry.Used = time.Now()
+  return cache.writeEntry(entry)
+}
+
+// Adds the downloaded file to the cache and evicts old files if
+// the cache has grown too large.
+func (cache *DownloadCache) store(entry downloadEntry, downloaded string) error {
+  cache.lock.Lock()
+  defer cache.lock.Unlock()
+
+  // another url might already have downloaded the same file.
+  filename := cache.filePath(entry.Hash)
+  if _, err := os.Stat(filename); os.IsNotExist(err) {
+    // copy instead of linking, the downloaded file belongs to the job
+    if err := copyFile(downloaded, filename + ".tmp"); err != nil {
+      return err
+    }
+
+    if err := os.Rename(filename + ".tmp", filename); err != nil {
+      return err
+    }
+  }
+
+  entry.Used = time.Now()
+  if err := cache.writeEntry(entry); err != nil {
+    return err
+  }
+
+  return cache.evict()
+}
+
+// Deletes the least recently used files until the cache fits into its size
+// limit. Also deletes entries whose file is gone. Expects the lock to be held.
+func (cache *DownloadCache) evict() error {
+  if cache.maxSize <= 0 {
+    return nil
+  }
+
+  entryFiles, err := filepath.Glob(filepath.Join(cache.directory, "urls", "*.json"))
+  if err != nil {
+    return err
+  }
+
+  // a file is as recently used as the most recently used url that references it.
+  used := map[string]time.Time{}
+  entriesOf := map[string][]string{}
+  for _, entryFile := range entryFiles {
+    var entry downloadEntry
+
+    content, err := ioutil.ReadFile(entryFile)
+    if err != nil || json.Unmarshal(content, &entry) != nil {
+      os.Remove(entryFile)
+      continue
+    }
+
+    entriesOf[entry.Hash] = append(entriesOf[entry.Hash], entryFile)
+    if entry.Used.After(used[entry.Hash]) {
+      used[entry.Hash] = entry.Used
+    }
+  }
+
+  var files []diskEntry
+  for hash, lastUsed := range used {
+    hash := hash
+    info, err := os.Stat(cache.filePath(hash))
+    if err != nil {
+      for _, entryFile := range entriesOf[hash] {
+        os.Remove(entryFile)
+      }
+
+      continue
+    }
+
+    files = append(files, diskEntry{
+      Size: info.Size(),
+      Used: lastUsed,
+      Delete: func() error {
+        logrus.Infof("Evicting cached download %s of %d bytes", hash, info.Size())
+
+        for _, entryFile := range entriesOf[hash] {
+          os.Remove(entryFile)
+        }
+
+        return os.Remove(cache.filePath(hash))
+      },
+    })
+  }
+
+  evictEntries(files, 0, cache.maxSize)
+  return nil
+}
+
+func (cache *DownloadCache) writeEntry(entry downloadEntry) error {
+  content, err := json.Marshal(entry)
+  if err != nil {
+    return err
+  }
+
+  return WriteFileAtomic(cache.entryPath(entry.Url), content)
+}
+
+func (cache *DownloadCache) entryPath(url string) string {
+  hash := sha256.Sum256([]byte(url))
+  return filepath.Join(cache.directory, "urls", hex.EncodeToString(hash[:]) + ".json")
+}
+
+func (cache *DownloadCache) filePath(hash string) string {
+  return filepath.Join(cache.directory, "files", hash)
+}
@@ -1,20 +1,21 @@
 package job
 
 import (
+  "context"
   "io"
-  "image"
+  "fmt"
   "os"
   "github.com/Sirupsen/logrus"
   "time"
   "path/filepath"
-  "sort"
-  "net/http"
   "github.com/pkg/errors"
+  "strconv"
+  "math"
+  "io/ioutil"
+  "strings"
 )
 
 func (job *Job) export() error {
-  var imageFiles []string
-
   log := logrus.WithField("id", job.Id)
 
   // print time it took to encode the video.
@@ -24,10 +25,10 @@ func (job *Job) export() error {
   }()
 
   project := job.Project
-  job.Progress = NewProgressMeter(5)
+  job.Progress = job.newProgressMeter(5)
 
   // create the workspace directory
-  workspace := "temp/export/" + job.Id
+  workspace := WorkspaceRoot + "/" + job.Id
   if err := os.MkdirAll(workspace, 0755); err != nil {
     return errors.WithMessage(err, "Could not create workspace")
   }
@@ -35,187 +36,444 @@ func (job *Job) export() error {
   // do cleanup if something fails.
   defer func() {
     log.Info("Cleanup")
-    cleanupWorkspace(workspace, imageFiles)
+    cleanupWorkspace(workspace)
   }()
 
-  log.Info("Downloading original video")
-  if err := downloadToFile(project.Video, workspace + "/original.mp4", job.Progress.Step(0)); err != nil {
-    return errors.WithMessage(err, "Could not download original video")
+  if err := project.Audio.Validate(); err != nil {
+    return err
+  }
+
+  job.setStage(StageDownload)
+  log.Info("Fetching original video")
+  if err := job.fetchInput(project.Video, workspace + "/original.mp4", job.Progress.Step(0)); err != nil {
+    return errors.WithMessage(err, "Could not fetch original video")
+  }
+
+  if project.Audio.Track != "" {
+    log.Info("Fetching audio track")
+    if err := job.fetchInput(project.Audio.Track, workspace + "/track", nil); err != nil {
+      return errors.WithMessage(err, "Could not fetch audio track")
+    }
+  }
+
+  if project.Audio.Music != "" {
+    log.Info("Fetching music")
+    if err := job.fetchInput(project.Audio.Music, workspace + "/music", nil); err != nil {
+      return errors.WithMessage(err, "Could not fetch music")
+    }
   }
 
   // read video information first - fail early
-  hasAudio := false
-  if !project.Silent {
-    log.Info("Check for audio stream in original video")
-    videoInfo, err := ReadVideoInfo(workspace + "/original.mp4")
+  job.setStage(StageProbe)
+  log.Info("Read video information from original video")
+  videoInfo, err := ReadVideoInfo(job.ctx, workspace + "/original.mp4")
+  if err != nil {
+    return errors.WithMessage(err, "Could not get video information from file.")
+  }
+
+  if err := videoInfo.CheckFormat(); err != nil {
+    return err
+  }
+
+  if _, ok := videoInfo.VideoStream(); !ok {
+    return rejectf("the video does not contain a video stream")
+  }
+
+  // the audio inputs might be any uploaded file, make sure they contain audio
+  var track *Stream
+  if project.Audio.Track != "" {
+    stream, err := readAudioStream(job.ctx, workspace + "/track")
     if err != nil {
-      return errors.WithMessage(err, "Could not get video information from file.")
+      return errors.WithMessage(err, "Could not read audio track")
+    }
+
+    track = &stream
+  }
+
+  if project.Audio.Music != "" {
+    if _, err := readAudioStream(job.ctx, workspace + "/music"); err != nil {
+      return errors.WithMessage(err, "Could not read music")
     }
+  }
+
+  if duration, ok := videoInfo.Duration(); ok && Limits.MaxDuration > 0 && duration > Limits.MaxDuration.Seconds() {
+    return rejectf("the video is longer than %s", Limits.MaxDuration)
+  }
 
-    hasAudio = len(videoInfo.Streams) > 1
+  profile, ok := LookupProfile(project.Profile)
+  if !ok {
+    return fmt.Errorf("Unknown output profile %q", project.Profile)
   }
 
-  log.Info("Converting video to frames (and downscale them)")
-  err := FFmpeg(workspace, job.Progress.Step(1), "-i", "original.mp4",
-    "-vf", "scale='min(iw,848)':-2,fps=25:start_time=0",
-    "-y", "-q:v", "5", "-an", "frame-%06d.jpg")
+  if err := project.ValidateTiming(); err != nil {
+    return err
+  }
+
+  var audio *Stream
+  if stream, ok := videoInfo.AudioStream(); ok && !project.Silent {
+    audio = &stream
+  }
 
+  // write the subtitles into a separate file, ffmpeg muxes it as a subtitle track.
+  // animations have neither audio nor subtitle tracks.
+  hasSubtitleTrack := project.SoftSubtitles() && !profile.IsAnimation() && len(project.Subtitles) > 0
+  if hasSubtitleTrack {
+    log.Info("Writing subtitle track")
+    if err := writeSubtitleTrack(workspace + "/subtitles.srt", project.Subtitles); err != nil {
+      return errors.WithMessage(err, "Could not write subtitle track")
+    }
+  }
+
+  var states []subtitleState
+  if project.BurnSubtitles() || profile.IsAnimation() {
+    states = subtitleStates(project.Subtitles)
+  }
+
+  width, height, hasSize := outputSize(videoInfo, profile.MaxWidth)
+  if !hasSize {
+    return errors.New("Could not find a video stream in the file")
+  }
+
+  rate, err := frameRateOf(project, videoInfo)
   if err != nil {
-    return errors.WithMessage(err, "Could not extract frames from video")
+    return err
+  }
+
+  if profile.MaxFrameRate > 0 && rate.Float() > profile.MaxFrameRate {
+    rate = FrameRateOf(profile.MaxFrameRate)
+  }
+
+  log.Infof("Exporting with %s frames per second", rate)
+
+  // both paths keep the frames in memory, streaming is only used once
+  // there are too many overlays for ffmpeg to composite them quickly.
+  var input encoderInput
+  if len(states) <= maxOverlays {
+    input, err = job.prepareOverlays(workspace, log, states, width, height, rate)
+  } else {
+    input, err = job.prepareStream(workspace, log, width, height, rate)
   }
 
-  log.Info("Looking for frames.")
-  imageFiles, err = filepath.Glob(workspace + "/frame-*.jpg")
   if err != nil {
-    return errors.WithMessage(err, "Could not find the generated frames")
+    return err
+  }
+
+  // encode the video
+  job.setStage(StageEncode)
+  job.OutputFile = workspace + "/rendered" + profile.Extension()
+  if err := job.encodeVideo(workspace, log, profile, input, audio, track, hasSubtitleTrack); err != nil {
+    return err
+  }
+
+  log.Info("Finished")
+  return nil
+}
+
+// Puts an input of the project into the target file. The input is either
+// the id of an uploaded file or an url to download.
+func (job *Job) fetchInput(input string, target string, progress ProgressUpdater) error {
+  if sourceId, ok := SourceIdOf(input); ok {
+    if err := copySource(sourceId, target); err != nil {
+      return err
+    }
+
+    if progress != nil {
+      progress(1, 1)
+    }
+
+    return nil
   }
 
-  // sort images correctly
-  sort.Strings(imageFiles)
+  return downloadToFile(job.ctx, input, target, progress)
+}
 
-  log.Info("Read image size from first frame")
-  config, err := readImageConfig(imageFiles[0])
+// Returns the first audio stream of an audio input. Files without audio are rejected.
+func readAudioStream(ctx context.Context, filename string) (Stream, error) {
+  info, err := ReadVideoInfo(ctx, filename)
   if err != nil {
-    return errors.WithMessage(err, "Could not read image size from first frame")
+    return Stream{}, err
+  }
+
+  if err := info.CheckFormat(); err != nil {
+    return Stream{}, err
+  }
+
+  streams := info.AudioStreams()
+  if len(streams) == 0 {
+    return Stream{}, rejectf("the file does not contain audio")
   }
 
-  fontSize := float64(config.Height) / 16
+  return streams[0], nil
+}
+
+// The video stream the encoder reads, either raw frames from stdin or the
+// original video with a filter graph to put the subtitles on top.
+type encoderInput struct {
+  // arguments to pass to ffmpeg to open the inputs
+  Args         []string
+
+  // number of inputs opened by Args
+  InputCount   int
+
+  // a filter graph that produces the stream [video], if any.
+  Filter       string
+
+  // writes the frames the encoder reads from stdin, if any.
+  Frames       func(w io.Writer, progress ProgressUpdater) error
+}
+
+// Renders one image per subtitle state that ffmpeg puts on top of the original video.
+func (job *Job) prepareOverlays(workspace string, log logrus.FieldLogger, states []subtitleState, width, height int, rate FrameRate) (encoderInput, error) {
+  job.Progress.Step(1)(1, 1)
+
+  fontSize := float64(height) / 16
+
+  job.setStage(StageRender)
 
   log.Info("Loading subtitle font-file")
   ff, err := LoadFontFace("assets/font.ttf", fontSize)
   if err != nil {
-    return errors.WithMessage(err, "Couldn ot load subtitle font")
+    return encoderInput{}, errors.WithMessage(err, "Could not load subtitle font")
   }
 
-  // update every image.
-  log.Infof("Render %d subtitles", len(project.Subtitles))
-  for idx, file := range imageFiles {
-    currentTime := float64(idx) / 25.0
+  log.Infof("Render %d overlays for %d subtitles", len(states), len(job.Project.Subtitles))
+  if err := job.renderOverlays(workspace, states, width, height, ff, fontSize); err != nil {
+    return encoderInput{}, err
+  }
 
-    // update the progress bar
-    job.Progress.Step(2)(idx, len(imageFiles))
+  input := encoderInput{
+    Args:       append(trimArgs(job.Project), untrustedInput("original.mp4")...),
+    InputCount: 1 + len(states),
+    Filter:     overlayFilter(states, sourceFilter(job.Project, width, height, rate)),
+  }
 
-    // get the list of subtitles for this image
-    var subtitles []Subtitle
-    for _, subtitle := range project.Subtitles {
-      if subtitle.Time <= currentTime && currentTime <= subtitle.Time + subtitle.Duration {
-        subtitles = append(subtitles, subtitle)
-      }
-    }
+  for idx := range states {
+    input.Args = append(input.Args, "-i", overlayFilename(idx))
+  }
 
-    // we do not need to touch the image if we have no subtitles
-    if len(subtitles) == 0 {
-      continue
-    }
+  return input, nil
+}
 
-    // draw them
-    if err := RenderSubtitles(file, ff, fontSize, subtitles); err != nil {
-      return errors.WithMessage(err, "Could not render subtitles")
-    }
+// Prepares streaming the frames of the original video through the renderer
+// into the encoder. Used if there are too many subtitles to use overlays.
+func (job *Job) prepareStream(workspace string, log logrus.FieldLogger, width, height int, rate FrameRate) (encoderInput, error) {
+  job.Progress.Step(1)(1, 1)
+  job.Progress.Step(2)(1, 1)
+
+  fontSize := float64(height) / 16
+
+  job.setStage(StageRender)
+
+  log.Info("Loading subtitle font-file")
+  ff, err := LoadFontFace("assets/font.ttf", fontSize)
+  if err != nil {
+    return encoderInput{}, errors.WithMessage(err, "Could not load subtitle font")
   }
 
-  // encode video to .mp4
-  job.OutputFile = workspace + "/rendered.mp4"
-  if err := job.encodeVideo(workspace, log, hasAudio); err != nil {
-    return err
+  input := encoderInput{
+    Args: []string{
+      "-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", width, height),
+      "-r", rate.String(), "-i", "-",
+    },
+
+    InputCount: 1,
+
+    Frames: func(w io.Writer, progress ProgressUpdater) error {
+      return job.streamFrames(workspace, w, width, height, rate, ff, fontSize, progress)
+    },
   }
 
-  log.Info("Finished")
-  return nil
+  return input, nil
 }
 
-func (job *Job) encodeVideo(workspace string, log logrus.FieldLogger, hasAudio bool) error {
-  bitrate := "600"
+// Returns the input arguments that seek to the start of the exported segment
+// and stop reading at its end.
+func trimArgs(project Project) []string {
+  var args []string
+  if project.Start > 0 {
+    args = append(args, "-ss", formatFloat(project.Start))
+  }
 
-  for passIndex, pass := range []string{"1", "2"} {
-    var command []string
-    command = append(command, "-r", "25", "-i", "frame-%06d.jpg")
+  if project.End > 0 {
+    args = append(args, "-t", formatFloat(project.End - project.Start))
+  }
 
-    hasAudioThisPass := passIndex > 0 && hasAudio
-    if hasAudioThisPass {
-      command = append(command, "-i", "original.mp4")
-    }
+  return args
+}
 
-    command = append(command, "-map", "0:v")
+// Returns the filters that turn the trimmed original video into frames
+// of the exported video, played back at the speed of the project.
+func sourceFilter(project Project, width, height int, rate FrameRate) string {
+  filter := fmt.Sprintf("scale=%d:%d,fps=%s:start_time=0", width, height, rate)
+  if speed := project.PlaybackSpeed(); speed != 1 {
+    filter = fmt.Sprintf("setpts=(PTS-STARTPTS)/%s,", formatFloat(speed)) + filter
+  }
+
+  return filter
+}
+
+// Returns the audio filter to play the audio at the given speed. The atempo
+// filter only supports factors between 0.5 and 2, so larger changes are chained.
+func tempoFilter(speed float64) string {
+  var filters []string
+  for ; speed > 2; speed /= 2 {
+    filters = append(filters, "atempo=2")
+  }
 
-    if hasAudioThisPass {
-      command = append(command, "-map", "1:a", "-codec:a", "copy", "-shortest")
+  for ; speed < 0.5; speed /= 0.5 {
+    filters = append(filters, "atempo=0.5")
+  }
+
+  filters = append(filters, "atempo=" + formatFloat(speed))
+  return strings.Join(filters, ",")
+}
+
+func formatFloat(value float64) string {
+  return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Returns the frame rate requested by the project, or the one of the original video.
+func frameRateOf(project Project, info *VideoInfo) (FrameRate, error) {
+  if project.FrameRate != 0 {
+    rate := FrameRateOf(project.FrameRate)
+    if !rate.Valid() {
+      return FrameRate{}, fmt.Errorf("Invalid frame rate %g", project.FrameRate)
     }
 
-    command = append(command,
-      "-b:v", bitrate + "k", "-codec:v", "libx264", "-profile:v", "high", "-level", "4.2",
-      "-pass", pass, "-y", "rendered.mp4")
+    return rate, nil
+  }
 
-    log.Infof("Encode frames to video (pass %s)", pass)
-    err := FFmpeg(workspace, job.Progress.Step(3 + passIndex), command...)
-    return errors.WithMessage(err, "Error encoding the video in pass " + pass)
+  if rate, ok := info.FrameRate(); ok {
+    return rate, nil
   }
 
-  return nil
+  return defaultFrameRate, nil
 }
 
-func downloadToFile(url string, target string, progress ProgressUpdater) error {
-  resp, err := http.Get(url)
-  if err != nil {
-    return err
+// Computes the size of the exported video: at most maxWidth pixels wide,
+// keeping the aspect ratio with an even height, like scale='min(iw,maxWidth)':-2
+func outputSize(info *VideoInfo, maxWidth int) (int, int, bool) {
+  width, height, ok := info.Dimensions()
+  if !ok {
+    return 0, 0, false
   }
 
-  defer resp.Body.Close()
+  scaledWidth := width
+  if scaledWidth > maxWidth {
+    scaledWidth = maxWidth
+  }
 
-  fp, err := os.Create(target)
-  if err != nil {
-    return err
+  scaledHeight := int(math.Floor(float64(height) * float64(scaledWidth) / float64(width) / 2 + 0.5)) * 2
+  return scaledWidth, scaledHeight, true
+}
+
+// Encodes the video input into the output file. The given audio stream
+// of the original video is used for the audio of the output, if any.
+// The track is the audio stream of the uploaded audio track, if any.
+func (job *Job) encodeVideo(workspace string, log logrus.FieldLogger, profile Profile, input encoderInput, audio, track *Stream, hasSubtitleTrack bool) error {
+  output := "rendered" + profile.Extension()
+
+  // let the profile add its own filters, e.g. a color palette for gifs
+  videoFilter := input.Filter
+  if videoFilter == "" {
+    videoFilter = "[0:v]null[video]"
   }
 
-  defer fp.Close()
+  videoFilter += ";\n" + profile.videoFilter("[video]", "[encoded]")
+
+  passes := profile.passes()
+
+  for passIndex, pass := range passes {
+    // only the last pass writes the final file with all streams
+    lastPass := passIndex == len(passes) - 1
+
+    var command []string
+    command = append(command, input.Args...)
 
-  var buffer [16 * 1024]byte
+    nextInput := input.InputCount
 
-  written := 0
-  for {
-    // read the buffer
-    n, err := resp.Body.Read(buffer[:])
-    if err == io.EOF {
-      break
+    var audioIn *audioInput
+    if lastPass && !profile.IsAnimation() {
+      audioIn = job.prepareAudio(audio, track, nextInput)
     }
 
-    if err != nil {
-      return err
+    filter := videoFilter
+    if audioIn != nil {
+      command = append(command, audioIn.Args...)
+      nextInput += audioIn.InputCount
+
+      if audioIn.Filter != "" {
+        filter += ";\n" + audioIn.Filter
+      }
     }
 
-    // write the data we've read
-    _, err = fp.Write(buffer[:n])
-    if err != nil {
-      return err
+    hasSubtitleTrackThisPass := lastPass && hasSubtitleTrack
+    subtitleInput := nextInput
+    if hasSubtitleTrackThisPass {
+      command = append(command, "-i", "subtitles.srt")
+      nextInput++
     }
 
-    if resp.ContentLength > 0 {
-      written += int(n)
-      progress(written, int(resp.ContentLength))
+    if err := ioutil.WriteFile(workspace + "/encode.filter", []byte(filter + "\n"), 0644); err != nil {
+      return errors.WithMessage(err, "Could not write filter script")
     }
-  }
 
-  return nil
-}
+    command = append(command, "-filter_complex_script", "encode.filter", "-map", "[encoded]")
 
-func readImageConfig(filename string) (image.Config, error) {
-  fp, err := os.Open(filename)
-  if err != nil {
-    return image.Config{}, err
-  }
+    if audioIn != nil {
+      command = append(command, "-map", audioIn.Map, "-shortest")
+      command = append(command, profile.audioArgs(audioIn.Codec)...)
+    }
+
+    if hasSubtitleTrackThisPass {
+      command = append(command, "-map", strconv.Itoa(subtitleInput) + ":s", "-codec:s", profile.subtitleCodec())
+    }
+
+    command = append(command, profile.videoArgs(pass)...)
+
+    if profile.MaxDuration > 0 {
+      command = append(command, "-t", formatFloat(profile.MaxDuration))
+    }
+
+    if lastPass {
+      command = append(command, "-y", output)
+    } else {
+      // earlier passes only collect statistics for the next pass
+      command = append(command, "-f", "null", "-y", "-")
+    }
+
+    log.Infof("Encode video with profile %s (pass %s)", profile.Name, pass)
 
-  defer fp.Close()
+    var err error
+    if input.Frames != nil {
+      err = job.encodeStream(workspace, input, job.Progress.Step(3 + passIndex), command...)
+    } else {
+      err = FFmpeg(job.ctx, workspace, job.Progress.Step(3 + passIndex), command...)
+    }
 
-  config, _, err := image.DecodeConfig(fp)
-  return config, err
+    if err != nil {
+      return errors.WithMessage(err, "Error encoding the video in pass " + pass)
+    }
+  }
+
+  // single pass encoding skips the progress of the second pass
+  job.Progress.Step(4)(1, 1)
+  return nil
 }
 
-func cleanupWorkspace(workspace string, imageFiles[] string) {
+func cleanupWorkspace(workspace string) {
   os.Remove(workspace + "/original.mp4")
-  os.Remove(workspace + "/ffmpeg2pass-0.log")
-  os.Remove(workspace + "/ffmpeg2pass-0.log.mbtree")
-  for _, file := range imageFiles {
+  os.Remove(workspace + "/subtitles.srt")
+  os.Remove(workspace + "/track")
+  os.Remove(workspace + "/music")
+  os.Remove(workspace + "/encode.filter")
+
+  overlays, _ := filepath.Glob(workspace + "/overlay-*.png")
+  for _, file := range overlays {
     os.Remove(file)
   }
+
+  os.Remove(workspace + "/ffmpeg2pass-0.log")
+  os.Remove(workspace + "/ffmpeg2pass-0.log.mbtree")
 }
 
@@ -1,50 +1,49 @@
 package job
 
 import (
+  "context"
   "time"
   "regexp"
   "strconv"
   "bytes"
   "os/exec"
-  "os"
-  "encoding/json"
   "github.com/Sirupsen/logrus"
   "strings"
   "io"
   "github.com/pkg/errors"
 )
 
-type VideoInfo struct {
-  Streams []struct {
-    Index int
-    Type  string `json:"codec_type"`
-  }
+func FFmpeg(ctx context.Context, workspace string, progress ProgressUpdater, args ...string) error {
+  return FFmpegWithInput(ctx, workspace, progress, nil, args...)
 }
 
-func ReadVideoInfo(filename string) (*VideoInfo, error) {
-  var stdout bytes.Buffer
-  cmd := exec.Command("ffprobe", "-hide_banner", "-loglevel", "error", "-print_format", "json", "-show_streams", filename)
+// Runs ffmpeg like FFmpeg, but feeds the given reader into its stdin.
+func FFmpegWithInput(ctx context.Context, workspace string, progress ProgressUpdater, stdin io.Reader, args ...string) error {
+  cmd, stderr := ffmpegCommand(ctx, workspace, progress, args...)
+  cmd.Stdin = stdin
 
-  cmd.Stdout = &stdout
-  cmd.Stderr = os.Stdout
+  // finish once ffmpeg stops
+  if progress != nil {
+    defer progress(1, 1)
+  }
 
   if err := cmd.Run(); err != nil {
-    return nil, errors.WithMessage(err, "Could not run ffprobe")
+    logrus.Warnf("ffmpeg failed with %s, stderr was: %s", err, stderr.String())
+    return errors.WithMessage(err, "Could not run ffmpeg")
   }
 
-  // decode result as json
-  var result VideoInfo
-  err := json.NewDecoder(&stdout).Decode(&result)
-  return &result, errors.WithMessage(err, "Could not decode ffprobe output")
+  return nil
 }
 
-func FFmpeg(workspace string, progress ProgressUpdater, args ...string) error {
+// Prepares an ffmpeg process running in the workspace. Its output is
+// collected in the returned buffer and parsed to report the progress.
+func ffmpegCommand(ctx context.Context, workspace string, progress ProgressUpdater, args ...string) (*exec.Cmd, *bytes.Buffer) {
   logrus.Debug("ffmpeg ", strings.Join(args, " "))
 
   // prepend a few defaults to the arguments
   args = append([]string{"-hide_banner", "-loglevel", "info", "-stats"}, args...)
 
-  cmd := exec.Command("ffmpeg", args...)
+  cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
   cmd.Dir = workspace
 
@@ -54,15 +53,7 @@ func FFmpeg(workspace string, progress ProgressUpdater, args ...string) error {
     Delegate: &stderr,
   }
 
-  // finish once ffmpeg stops
-  defer progress(1, 1)
-
-  if err := cmd.Run(); err != nil {
-    logrus.Warn("ffmpeg failed with %s, stderr was: %s", err, stderr.String())
-    return errors.WithMessage(err, "Could not run ffmpeg")
-  }
-
-  return nil
+  return cmd, &stderr
 }
 
 type ffmpegTimeProgressWriter struct {
@@ -0,0 +1,81 @@
+package job
+
+import (
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+)
+
+// Writes the data to a temporary file next to the target first and renames it
+// afterwards, so a crash never leaves a half written file behind.
+func WriteFileAtomic(filename string, data []byte) error {
+  fp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename) + ".tmp")
+  if err != nil {
+    return err
+  }
+
+  defer os.Remove(fp.Name())
+  defer fp.Close()
+
+  if _, err := fp.Write(data); err != nil {
+    return err
+  }
+
+  if err := fp.Chmod(0644); err != nil {
+    return err
+  }
+
+  if err := fp.Sync(); err != nil {
+    return err
+  }
+
+  if err := fp.Close(); err != nil {
+    return err
+  }
+
+  return os.Rename(fp.Name(), filename)
+}
+
+// Creates the target file, replacing an existing one instead of writing into it. The
+// target might be a hard link into the download cache left behind by a crash.
+func createFile(target string) (*os.File, error) {
+  if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }
+
+  return os.OpenFile(target, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+}
+
+// Hard links the source file to the target, or copies it if linking
+// is not possible. Only use this for files that are never modified.
+func linkOrCopy(source, target string) error {
+  if err := os.Link(source, target); err == nil {
+    return nil
+  }
+
+  return copyFile(source, target)
+}
+
+// Copies the source into a new target file.
+func copyFile(source, target string) error {
+  input, err := os.Open(source)
+  if err != nil {
+    return err
+  }
+
+  defer input.Close()
+
+  output, err := createFile(target)
+  if err != nil {
+    return err
+  }
+
+  defer output.Close()
+
+  if _, err := io.Copy(output, input); err != nil {
+    return err
+  }
+
+  return output.Close()
+}
@@ -0,0 +1,153 @@
+package job
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+
+  "github.com/Sirupsen/logrus"
+  "github.com/pkg/errors"
+)
+
+// Persists every job as a json file in a directory. All jobs are
+// loaded into memory on startup, so this is meant for single node setups.
+type FileJobStore struct {
+  directory   string
+  jobs        *JobManager
+  interrupted []*Job
+
+  // jobs are written from the http handlers and the workers at the same time
+  lock        sync.Mutex
+}
+
+// Expired jobs are forgotten after this time, so the directory does not grow forever.
+// Afterwards their videos are answered as unknown instead of expired.
+const expiredJobRetention = 30 * 24 * time.Hour
+
+type jobFile struct {
+  Id         string    `json:"id"`
+  Project    Project   `json:"project"`
+  Status     Status    `json:"status"`
+  Stage      Stage     `json:"stage"`
+  Error      string    `json:"error"`
+  OutputFile string    `json:"outputFile"`
+  Created    time.Time `json:"created"`
+  Updated    time.Time `json:"updated"`
+}
+
+func NewFileJobStore(directory string) (*FileJobStore, error) {
+  if err := os.MkdirAll(directory, 0755); err != nil {
+    return nil, errors.WithMessage(err, "Could not create job directory")
+  }
+
+  store := &FileJobStore{directory: directory, jobs: NewJobManager()}
+
+  files, err := filepath.Glob(filepath.Join(directory, "*.json"))
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not list job files")
+  }
+
+  for _, file := range files {
+    job, updated, err := readJobFile(file)
+    if err != nil {
+      // a broken file should not prevent the server from starting.
+      logrus.WithField("file", file).Warn("Could not load job: ", err)
+      continue
+    }
+
+    if job.Status() == StatusExpired {
+      if time.Since(updated) > expiredJobRetention {
+        if err := os.Remove(file); err != nil {
+          logrus.WithField("file", file).Warn("Could not delete expired job: ", err)
+        }
+
+        continue
+      }
+
+      store.jobs.Expire(job.Id)
+      continue
+    }
+
+    if isInterrupted(job.Status()) {
+      job.requeue()
+      store.interrupted = append(store.interrupted, job)
+    }
+
+    store.jobs.Put(job)
+  }
+
+  return store, nil
+}
+
+// Reads the job and the time it was last written from a job file.
+func readJobFile(filename string) (*Job, time.Time, error) {
+  content, err := ioutil.ReadFile(filename)
+  if err != nil {
+    return nil, time.Time{}, err
+  }
+
+  var stored jobFile
+  if err := json.Unmarshal(content, &stored); err != nil {
+    return nil, time.Time{}, err
+  }
+
+  job := restoreJob(stored.Id, stored.Project, stored.Status, stored.Stage,
+    stored.Error, stored.OutputFile, stored.Created)
+
+  return job, stored.Updated, nil
+}
+
+func (store *FileJobStore) Expire(id string) error {
+  store.jobs.Expire(id)
+
+  // only keep the id and status in the file.
+  return store.Put(expiredJob(id))
+}
+
+func (store *FileJobStore) Put(job *Job) error {
+  if job.Status() != StatusExpired {
+    store.jobs.Put(job)
+  }
+
+  // read the state of the job while holding the lock,
+  // so an older state never overwrites a newer one.
+  store.lock.Lock()
+  defer store.lock.Unlock()
+
+  stored := jobFile{
+    Id:         job.Id,
+    Project:    job.Project,
+    Status:     job.Status(),
+    Stage:      job.Stage(),
+    OutputFile: job.OutputFile,
+    Created:    job.Created,
+    Updated:    time.Now(),
+  }
+
+  if err := job.Error(); err != nil {
+    stored.Error = err.Error()
+  }
+
+  content, err := json.Marshal(stored)
+  if err != nil {
+    return errors.WithMessage(err, "Could not encode job")
+  }
+
+  filename := filepath.Join(store.directory, job.Id + ".json")
+  return errors.WithMessage(WriteFileAtomic(filename, content), "Could not write job file")
+}
+
+func (store *FileJobStore) Get(id string) (*Job, error) {
+  return store.jobs.Get(id)
+}
+
+func (store *FileJobStore) Interrupted() ([]*Job, error) {
+  return store.interrupted, nil
+}
+
+func (store *FileJobStore) Active() ([]*Job, error) {
+  return store.jobs.Active()
+}
@@ -0,0 +1,78 @@
+package job
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// Used if neither the project nor the video provide a usable frame rate.
+var defaultFrameRate = FrameRate{Num: 25, Den: 1}
+
+// A frame rate as a fraction, like ffprobe reports it, e.g. 30000/1001.
+type FrameRate struct {
+  Num int
+  Den int
+}
+
+// Parses a frame rate like "30000/1001" or "25".
+func ParseFrameRate(value string) (FrameRate, bool) {
+  parts := strings.SplitN(value, "/", 2)
+
+  num, err := strconv.Atoi(parts[0])
+  if err != nil {
+    return FrameRate{}, false
+  }
+
+  den := 1
+  if len(parts) == 2 {
+    if den, err = strconv.Atoi(parts[1]); err != nil {
+      return FrameRate{}, false
+    }
+  }
+
+  rate := FrameRate{Num: num, Den: den}
+  return rate, rate.Valid()
+}
+
+// Converts a frame rate in frames per second, with up to three decimals.
+func FrameRateOf(fps float64) FrameRate {
+  num, den := int(fps * 1000 + 0.5), 1000
+  if divisor := gcd(num, den); divisor > 1 {
+    num, den = num / divisor, den / divisor
+  }
+
+  return FrameRate{Num: num, Den: den}
+}
+
+func gcd(a, b int) int {
+  for b != 0 {
+    a, b = b, a % b
+  }
+
+  return a
+}
+
+// Returns true, if this is a frame rate we can encode. Some containers
+// report rates like 90000/1 for variable frame rate videos.
+func (rate FrameRate) Valid() bool {
+  return rate.Num > 0 && rate.Den > 0 && rate.Float() >= 1 && rate.Float() <= 120
+}
+
+func (rate FrameRate) Float() float64 {
+  return float64(rate.Num) / float64(rate.Den)
+}
+
+// Returns the time of the frame with the given index in seconds.
+func (rate FrameRate) TimeOf(frameIndex int) float64 {
+  return float64(frameIndex) * float64(rate.Den) / float64(rate.Num)
+}
+
+// Formats the frame rate for ffmpeg.
+func (rate FrameRate) String() string {
+  if rate.Den == 1 {
+    return strconv.Itoa(rate.Num)
+  }
+
+  return fmt.Sprintf("%d/%d", rate.Num, rate.Den)
+}
@@ -0,0 +1,227 @@
+package job
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "time"
+
+  "github.com/Sirupsen/logrus"
+  "github.com/pkg/errors"
+)
+
+// Deletes the workspaces of old jobs and old uploaded videos, so the disk does not fill up.
+type Janitor struct {
+  Store   JobStore
+
+  // Workspaces and uploads older than this are deleted. Zero means no limit.
+  MaxAge  time.Duration
+
+  // Oldest workspaces and uploads are deleted until all of them fit
+  // into this number of bytes. Zero means no limit.
+  MaxSize int64
+}
+
+type workspaceInfo struct {
+  Id       string
+  Path     string
+  Size     int64
+  Modified time.Time
+
+  // an uploaded video instead of the workspace of a job
+  Source   bool
+}
+
+// Runs a cleanup now and then again after each interval. Never returns.
+func (janitor *Janitor) Run(interval time.Duration) {
+  for {
+    if err := janitor.Cleanup(); err != nil {
+      logrus.Warn("Could not cleanup workspaces: ", err)
+    }
+
+    time.Sleep(interval)
+  }
+}
+
+// Deletes all workspaces that are too old or do not fit into the disk budget,
+// and marks their jobs as expired. Workspaces of running jobs are never deleted.
+func (janitor *Janitor) Cleanup() error {
+  workspaces, err := janitor.listWorkspaces()
+  if err != nil {
+    return err
+  }
+
+  sources, err := janitor.listSources()
+  if err != nil {
+    return err
+  }
+
+  workspaces = append(workspaces, sources...)
+
+  var entries []diskEntry
+  for _, workspace := range workspaces {
+    workspace := workspace
+    entries = append(entries, diskEntry{
+      Size: workspace.Size,
+      Used: workspace.Modified,
+      Delete: func() error {
+        return janitor.delete(workspace)
+      },
+    })
+  }
+
+  evictEntries(entries, janitor.MaxAge, janitor.MaxSize)
+  return nil
+}
+
+// Deletes the workspace or uploaded video and expires the job of a workspace.
+func (janitor *Janitor) delete(workspace workspaceInfo) error {
+  log := logrus.WithField("id", workspace.Id)
+  log.Infof("Deleting %s of %d bytes, last modified at %s", workspace.Path, workspace.Size, workspace.Modified)
+
+  if err := os.RemoveAll(workspace.Path); err != nil {
+    log.Warn("Could not delete workspace: ", err)
+    return err
+  }
+
+  if !workspace.Source {
+    if err := janitor.Store.Expire(workspace.Id); err != nil {
+      log.Warn("Could not expire job: ", err)
+    }
+  }
+
+  return nil
+}
+
+// Something on the disk that is deleted once it is too old or does not fit into the disk budget.
+type diskEntry struct {
+  Size   int64
+  Used   time.Time
+  Delete func() error
+}
+
+// Deletes the entries last used longer than maxAge ago, and the least recently used
+// entries until the rest fits into maxSize bytes. A limit of zero is not checked.
+func evictEntries(entries []diskEntry, maxAge time.Duration, maxSize int64) {
+  // oldest entries go first
+  sort.Slice(entries, func(i, j int) bool {
+    return entries[i].Used.Before(entries[j].Used)
+  })
+
+  var totalSize int64
+  for _, entry := range entries {
+    totalSize += entry.Size
+  }
+
+  for _, entry := range entries {
+    tooOld := maxAge > 0 && time.Since(entry.Used) > maxAge
+    tooBig := maxSize > 0 && totalSize > maxSize
+    if !tooOld && !tooBig {
+      continue
+    }
+
+    if entry.Delete() == nil {
+      totalSize -= entry.Size
+    }
+  }
+}
+
+// Lists the uploaded videos that are not used by queued or running jobs.
+// Uploads that are still in progress are left out too.
+func (janitor *Janitor) listSources() ([]workspaceInfo, error) {
+  files, err := ioutil.ReadDir(SourceRoot)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not list uploaded videos")
+  }
+
+  activeJobs, err := janitor.Store.Active()
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not look up active jobs")
+  }
+
+  inUse := map[string]bool{}
+  for _, job := range activeJobs {
+    for _, input := range []string{job.Project.Video, job.Project.Audio.Track, job.Project.Audio.Music} {
+      if id, ok := SourceIdOf(input); ok {
+        inUse[id] = true
+      }
+    }
+  }
+
+  var sources []workspaceInfo
+  for _, file := range files {
+    if file.IsDir() || !sourceIdPattern.MatchString(file.Name()) || inUse[file.Name()] {
+      continue
+    }
+
+    sources = append(sources, workspaceInfo{
+      Id:       file.Name(),
+      Path:     sourcePath(file.Name()),
+      Size:     file.Size(),
+      Modified: file.ModTime(),
+      Source:   true,
+    })
+  }
+
+  return sources, nil
+}
+
+// Lists the workspaces of all jobs that are not queued or running.
+func (janitor *Janitor) listWorkspaces() ([]workspaceInfo, error) {
+  directories, err := ioutil.ReadDir(WorkspaceRoot)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not list workspaces")
+  }
+
+  var workspaces []workspaceInfo
+  for _, directory := range directories {
+    if !directory.IsDir() {
+      continue
+    }
+
+    job, err := janitor.Store.Get(directory.Name())
+    if err != nil {
+      return nil, errors.WithMessage(err, "Could not look up job")
+    }
+
+    if job != nil && isInterrupted(job.Status()) {
+      continue
+    }
+
+    workspace := workspaceInfo{
+      Id:       directory.Name(),
+      Path:     filepath.Join(WorkspaceRoot, directory.Name()),
+      Modified: directory.ModTime(),
+    }
+
+    err = filepath.Walk(workspace.Path, func(path string, info os.FileInfo, err error) error {
+      if err != nil {
+        return err
+      }
+
+      workspace.Size += info.Size()
+      if info.ModTime().After(workspace.Modified) {
+        workspace.Modified = info.ModTime()
+      }
+
+      return nil
+    })
+
+    if err != nil {
+      return nil, errors.WithMessage(err, "Could not measure workspace")
+    }
+
+    workspaces = append(workspaces, workspace)
+  }
+
+  return workspaces, nil
+}
@@ -1,9 +1,43 @@
 package job
 
 import (
+  "context"
+  "os"
   "github.com/Sirupsen/logrus"
+  "github.com/pkg/errors"
   "math/rand"
   "sync"
+  "time"
+)
+
+type Status string
+
+const (
+  StatusQueued  Status = "queued"
+  StatusRunning Status = "running"
+  StatusFailed  Status = "failed"
+  StatusDone    Status = "done"
+
+  // The job was done, but its output has been deleted.
+  StatusExpired Status = "expired"
+
+  // The job was cancelled by the user before it was done.
+  StatusCancelled Status = "cancelled"
+)
+
+var errCancelled = errors.New("Cancelled by user")
+
+// Directory containing the workspace of every job.
+const WorkspaceRoot = "temp/export"
+
+// A step in the export pipeline of a job.
+type Stage string
+
+const (
+  StageDownload Stage = "download"
+  StageProbe    Stage = "probe"
+  StageRender   Stage = "render"
+  StageEncode   Stage = "encode"
 )
 
 type Job struct {
@@ -11,17 +45,106 @@ type Job struct {
   OutputFile string
   Project    Project
   Progress   *Meter
+  Created    time.Time
 
   lock       sync.Mutex
   error      error
+  status     Status
+  stage      Stage
+
+  // cancelled if the user cancels the job
+  ctx        context.Context
+  cancel     context.CancelFunc
+
+  changes    notifier
 }
 
 func NewJob(project Project) *Job {
-  return &Job{
+  ctx, cancel := context.WithCancel(context.Background())
+
+  job := &Job{
     Id:      randStringBytes(12),
-    Progress: NewProgressMeter(1),
     Project: project,
+    Created:  time.Now(),
+    status:   StatusQueued,
+    ctx:      ctx,
+    cancel:   cancel,
+  }
+
+  job.Progress = job.newProgressMeter(1)
+  return job
+}
+
+// Creates a progress meter that reports its changes to subscribers of the job.
+func (job *Job) newProgressMeter(steps int) *Meter {
+  meter := NewProgressMeter(steps)
+  meter.changed = job.changes.notify
+  return meter
+}
+
+// Returns a channel that receives a value after the status, stage or
+// progress of the job has changed. Call the returned function to unsubscribe.
+func (job *Job) Changes() (<-chan struct{}, func()) {
+  ch := job.changes.subscribe()
+  return ch, func() { job.changes.unsubscribe(ch) }
+}
+
+func (job *Job) Status() Status {
+  job.lock.Lock()
+  defer job.lock.Unlock()
+
+  return job.status
+}
+
+// Returns the stage the job is currently in. If the job has failed,
+// this is the stage that caused the failure.
+func (job *Job) Stage() Stage {
+  job.lock.Lock()
+  defer job.lock.Unlock()
+
+  return job.stage
+}
+
+// Marks a queued job as running. Returns false, if the
+// job was cancelled or has already finished.
+func (job *Job) start() bool {
+  job.lock.Lock()
+  defer job.lock.Unlock()
+
+  if job.status != StatusQueued && job.status != StatusRunning {
+    return false
+  }
+
+  job.status = StatusRunning
+  job.changes.notify()
+  return true
+}
+
+// Cancels a queued or running job and kills its ffmpeg processes. Returns
+// false, if the job can not be cancelled because it has already finished.
+func (job *Job) Cancel() bool {
+  job.lock.Lock()
+  if job.status != StatusQueued && job.status != StatusRunning {
+    job.lock.Unlock()
+    return false
   }
+
+  job.status = StatusCancelled
+  job.error = errCancelled
+  job.lock.Unlock()
+
+  job.cancel()
+  job.Progress.FinishNow()
+  job.changes.notify()
+  return true
+}
+
+func (job *Job) setStage(stage Stage) {
+  job.lock.Lock()
+  job.stage = stage
+  job.lock.Unlock()
+
+  job.changes.notify()
 }
 
 func (job *Job) Error() error {
@@ -37,15 +160,37 @@ func (job *Job) Finished() bool {
 }
 
 func (job *Job) Execute() error {
-  defer job.Progress.FinishNow()
+  // export replaces the progress meter, so do not bind the old one.
+  defer func() { job.Progress.FinishNow() }()
+
+  if !job.start() {
+    return errCancelled
+  }
 
   err := job.export()
 
   // store error code on lock
   job.lock.Lock()
-  job.error = err
+  cancelled := job.status == StatusCancelled
+  switch {
+  case cancelled:
+    err = errCancelled
+
+  case err != nil:
+    job.error = err
+    job.status = StatusFailed
+
+  default:
+    job.status = StatusDone
+  }
   job.lock.Unlock()
 
+  if cancelled {
+    // nobody will ever download the output
+    os.RemoveAll(WorkspaceRoot + "/" + job.Id)
+  }
+
+  job.changes.notify()
   return err
 }
 
@@ -61,7 +206,7 @@ func randStringBytes(n int) string {
   return string(b)
 }
 
-func Execute(concurrency int, jobs <-chan *Job) {
+func Execute(concurrency int, jobs <-chan *Job, store JobStore) {
   limitter := make(chan bool, concurrency)
   defer close(limitter)
 
@@ -77,8 +222,23 @@ func Execute(concurrency int, jobs <-chan *Job) {
         <-limitter
       }()
 
+      log := logrus.WithField("id", job.Id)
+
+      if !job.start() {
+        log.Info("Skipping cancelled job")
+        return
+      }
+
+      if err := store.Put(job); err != nil {
+        log.Warn("Could not store job: ", err)
+      }
+
       if err := job.Execute(); err != nil {
-        logrus.WithField("id", job.Id).Error("Export failed with error: ", err)
+        log.Error("Export failed with error: ", err)
+      }
+
+      if err := store.Put(job); err != nil {
+        log.Warn("Could not store job: ", err)
       }
     }()
   }
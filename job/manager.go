@@ -4,24 +4,64 @@ import "sync"
 
 // more or less a synchronized map of jobs
 type JobManager struct {
-  lock sync.Mutex
-  jobs map[string]*Job
+  lock    sync.Mutex
+  jobs    map[string]*Job
+  expired map[string]bool
 }
 
 func NewJobManager() *JobManager {
-  return &JobManager{jobs: make(map[string]*Job)}
+  return &JobManager{
+    jobs:    make(map[string]*Job),
+    expired: make(map[string]bool),
+  }
 }
 
-func (jm *JobManager) Put(job *Job) {
+func (jm *JobManager) Put(job *Job) error {
   jm.lock.Lock()
   jm.jobs[job.Id] = job
   jm.lock.Unlock()
+
+  return nil
 }
 
-func (jm *JobManager) Get(id string) *Job {
+func (jm *JobManager) Get(id string) (*Job, error) {
   jm.lock.Lock()
   job := jm.jobs[id]
+  expired := jm.expired[id]
+  jm.lock.Unlock()
+
+  if job == nil && expired {
+    return expiredJob(id), nil
+  }
+
+  return job, nil
+}
+
+// Jobs in memory do not survive a restart, so there is nothing to resume.
+func (jm *JobManager) Interrupted() ([]*Job, error) {
+  return nil, nil
+}
+
+func (jm *JobManager) Active() ([]*Job, error) {
+  jm.lock.Lock()
+  defer jm.lock.Unlock()
+
+  var active []*Job
+  for _, job := range jm.jobs {
+    if isInterrupted(job.Status()) {
+      active = append(active, job)
+    }
+  }
+
+  return active, nil
+}
+
+// Removes the job, only remembering that it existed.
+func (jm *JobManager) Expire(id string) error {
+  jm.lock.Lock()
+  delete(jm.jobs, id)
+  jm.expired[id] = true
   jm.lock.Unlock()
 
-  return job
+  return nil
 }
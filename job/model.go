@@ -1,19 +1,130 @@
 package job
 
+import "github.com/pkg/errors"
+
+// Defines how the subtitles end up in the exported video.
+type SubtitleMode string
+
+const (
+	// Draw the subtitles into the frames of the video (the default).
+	SubtitlesBurnedIn SubtitleMode = "burn"
+
+	// Add the subtitles as a separate track players can toggle.
+	SubtitlesSoft SubtitleMode = "soft"
+
+	// Draw the subtitles and add them as a separate track.
+	SubtitlesBoth SubtitleMode = "both"
+)
+
 type Project struct {
-	Id        string
-	Video     string
-	Silent    bool
-	Subtitles []Subtitle
+	Id           string       `json:"id"`
+	Video        string       `json:"video"`
+	// Removes the audio of the original video. An uploaded track or music is still added.
+	Silent       bool         `json:"silent"`
+	SubtitleMode SubtitleMode `json:"subtitleMode"`
+
+	// Frame rate of the exported video. Uses the frame rate of the original video if zero.
+	FrameRate    float64      `json:"frameRate"`
+
+	// Name of the output profile, uses the DefaultProfile if empty.
+	Profile      string       `json:"profile"`
+
+	// Segment of the original video to export in seconds. An end of zero
+	// exports everything after the start.
+	Start        float64      `json:"start"`
+	End          float64      `json:"end"`
+
+	// Playback speed of the exported video, normal speed if zero. Subtitle
+	// times are relative to the trimmed video at this speed.
+	Speed        float64      `json:"speed"`
+
+	Audio        AudioOptions `json:"audio"`
+
+	Subtitles    []Subtitle   `json:"subtitles"`
+}
+
+func (project Project) BurnSubtitles() bool {
+	return project.SubtitleMode != SubtitlesSoft
+}
+
+func (project Project) SoftSubtitles() bool {
+	return project.SubtitleMode == SubtitlesSoft || project.SubtitleMode == SubtitlesBoth
+}
+
+// Returns the playback speed, one if none is set.
+func (project Project) PlaybackSpeed() float64 {
+	if project.Speed == 0 {
+		return 1
+	}
+
+	return project.Speed
+}
+
+// Checks that the trim points and the playback speed make sense.
+func (project Project) ValidateTiming() error {
+	if project.Start < 0 || project.End < 0 {
+		return errors.New("Trim points must not be negative")
+	}
+
+	if project.End != 0 && project.End <= project.Start {
+		return errors.New("The end must be after the start")
+	}
+
+	if speed := project.PlaybackSpeed(); speed < MinSpeed || speed > MaxSpeed {
+		return errors.Errorf("The speed must be between %g and %g", MinSpeed, MaxSpeed)
+	}
+
+	return nil
+}
+
+// Limits of the playback speed.
+const (
+	MinSpeed = 0.25
+	MaxSpeed = 4.0
+)
+
+// Changes to the audio of the exported video.
+type AudioOptions struct {
+	// Url or uploaded file of an audio track that replaces the audio of the original video.
+	Track       string  `json:"track"`
+
+	// Url or uploaded file of music that is mixed under the audio and
+	// lowered while the audio is loud.
+	Music       string  `json:"music"`
+
+	// Volume of the music between 0 and MaxMusicVolume, defaults to 0.3 if zero.
+	MusicVolume float64 `json:"musicVolume"`
+
+	// Normalizes the loudness of the result according to EBU R128.
+	Normalize   bool    `json:"normalize"`
+}
+
+const MaxMusicVolume = 2.0
+
+// Checks that the audio options make sense.
+func (options AudioOptions) Validate() error {
+	if options.MusicVolume < 0 || options.MusicVolume > MaxMusicVolume {
+		return errors.Errorf("The music volume must be between 0 and %g", MaxMusicVolume)
+	}
+
+	return nil
+}
+
+func (options AudioOptions) musicVolume() float64 {
+	if options.MusicVolume == 0 {
+		return 0.3
+	}
+
+	return options.MusicVolume
 }
 
 type Subtitle struct {
-	Text     string
-	Time     float64
-	Duration float64
-	Color    string
+	Text     string  `json:"text"`
+	Time     float64 `json:"time"`
+	Duration float64 `json:"duration"`
+	Color    string  `json:"color"`
 	Position struct {
-		X string
-		Y string
-	}
+		X string `json:"x"`
+		Y string `json:"y"`
+	} `json:"position"`
 }
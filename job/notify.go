@@ -0,0 +1,43 @@
+package job
+
+import "sync"
+
+// Wakes up everyone interested in changes of a job.
+type notifier struct {
+  lock      sync.Mutex
+  listeners map[chan struct{}]bool
+}
+
+func (n *notifier) subscribe() chan struct{} {
+  // a buffer of one is enough, multiple changes are merged into one notification.
+  ch := make(chan struct{}, 1)
+
+  n.lock.Lock()
+  if n.listeners == nil {
+    n.listeners = make(map[chan struct{}]bool)
+  }
+
+  n.listeners[ch] = true
+  n.lock.Unlock()
+
+  return ch
+}
+
+func (n *notifier) unsubscribe(ch chan struct{}) {
+  n.lock.Lock()
+  delete(n.listeners, ch)
+  n.lock.Unlock()
+}
+
+func (n *notifier) notify() {
+  n.lock.Lock()
+  defer n.lock.Unlock()
+
+  for ch := range n.listeners {
+    select {
+    case ch <- struct{}{}:
+    default:
+      // already notified
+    }
+  }
+}
@@ -0,0 +1,124 @@
+package job
+
+import (
+  "bytes"
+  "fmt"
+  "sort"
+
+  "github.com/pkg/errors"
+  "golang.org/x/image/font"
+)
+
+// Compositing gets slow with too many overlays, as every frame passes through
+// every overlay filter. Projects with more states are streamed frame by frame
+// through the renderer instead. Below this number the overlays are faster:
+// ffmpeg decodes, composites and encodes in a single process, while streaming
+// copies every raw frame through two pipes and draws it in Go. Neither path
+// writes single frames to the disk.
+const maxOverlays = 64
+
+// A set of subtitles that is visible without change for some time.
+type subtitleState struct {
+  Start     float64
+  End       float64
+  Subtitles []Subtitle
+
+  indices   []int
+}
+
+// Splits the timeline into the intervals in which the visible subtitles do not change.
+func subtitleStates(subtitles []Subtitle) []subtitleState {
+  var times []float64
+  for _, subtitle := range subtitles {
+    times = append(times, subtitle.Time, subtitle.Time + subtitle.Duration)
+  }
+
+  sort.Float64s(times)
+
+  var states []subtitleState
+  for idx := 0; idx + 1 < len(times); idx++ {
+    start, end := times[idx], times[idx + 1]
+    if start == end {
+      continue
+    }
+
+    // look at the middle of the interval to find the visible subtitles
+    middle := (start + end) / 2
+
+    var state subtitleState
+    for subtitleIndex, subtitle := range subtitles {
+      if subtitle.Time <= middle && middle <= subtitle.Time + subtitle.Duration {
+        state.Subtitles = append(state.Subtitles, subtitle)
+        state.indices = append(state.indices, subtitleIndex)
+      }
+    }
+
+    if len(state.Subtitles) == 0 {
+      continue
+    }
+
+    // extend the previous state if nothing has changed
+    if count := len(states); count > 0 && states[count - 1].End == start && equalIndices(states[count - 1].indices, state.indices) {
+      states[count - 1].End = end
+      continue
+    }
+
+    state.Start, state.End = start, end
+    states = append(states, state)
+  }
+
+  return states
+}
+
+func equalIndices(a, b []int) bool {
+  if len(a) != len(b) {
+    return false
+  }
+
+  for idx := range a {
+    if a[idx] != b[idx] {
+      return false
+    }
+  }
+
+  return true
+}
+
+func overlayFilename(idx int) string {
+  return fmt.Sprintf("overlay-%04d.png", idx)
+}
+
+// Renders one transparent image per state into the workspace.
+func (job *Job) renderOverlays(workspace string, states []subtitleState, width, height int, ff font.Face, fontSize float64) error {
+  for idx, state := range states {
+    if err := job.ctx.Err(); err != nil {
+      return err
+    }
+
+    job.Progress.Step(2)(idx, len(states))
+
+    filename := workspace + "/" + overlayFilename(idx)
+    if err := RenderOverlay(filename, width, height, ff, fontSize, state.Subtitles); err != nil {
+      return errors.WithMessage(err, "Could not render overlay")
+    }
+  }
+
+  return nil
+}
+
+// Builds a filter graph that passes the first input through the source filter
+// and puts the overlay images (the following inputs) on top of it, each one only
+// while its state is visible. The resulting stream is labeled [video].
+func overlayFilter(states []subtitleState, source string) string {
+  var filter bytes.Buffer
+  fmt.Fprintf(&filter, "[0:v]%s[base0]", source)
+
+  for idx, state := range states {
+    // end a moment before the next state starts, so they never overlap.
+    fmt.Fprintf(&filter, ";\n[base%d][%d:v]overlay=enable='between(t,%.3f,%.3f)'[base%d]",
+      idx, idx + 1, state.Start, state.End - 0.001, idx + 1)
+  }
+
+  fmt.Fprintf(&filter, ";\n[base%d]null[video]", len(states))
+  return filter.String()
+}
@@ -0,0 +1,211 @@
+package job
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+)
+
+// Limits the videos and audio files jobs download, so the server can not be
+// used to reach into the internal network or to fill up the disk.
+type DownloadPolicy struct {
+  // Hosts to download from, including their subdomains. Any host if empty.
+  AllowedHosts []string
+
+  // Allows downloading from loopback and private network addresses.
+  AllowPrivate bool
+
+  // Maximum size of a download in bytes. Zero means no limit.
+  MaxSize      int64
+
+  // Maximum duration of the original video. Zero means no limit.
+  MaxDuration  time.Duration
+
+  // A download is stopped if it takes longer. Zero means no limit.
+  Timeout      time.Duration
+}
+
+// The policy for all downloads, configured on startup.
+var Limits = DownloadPolicy{
+  MaxSize:     512 * 1024 * 1024,
+  MaxDuration: 10 * time.Minute,
+  Timeout:     5 * time.Minute,
+}
+
+// Returned if a source is not allowed by the download policy.
+type RejectedError struct {
+  Reason string
+}
+
+func (err *RejectedError) Error() string {
+  return "Source rejected: " + err.Reason
+}
+
+func rejectf(format string, args ...interface{}) error {
+  return &RejectedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Networks that are not reachable from the internet.
+var privateNetworks = parseNetworks(
+  "0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+  "172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+  "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+  var networks []*net.IPNet
+  for _, cidr := range cidrs {
+    _, network, err := net.ParseCIDR(cidr)
+    if err != nil {
+      panic(err)
+    }
+
+    networks = append(networks, network)
+  }
+
+  return networks
+}
+
+func isPrivateAddress(ip net.IP) bool {
+  for _, network := range privateNetworks {
+    if network.Contains(ip) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// Checks the url before anything is downloaded from it.
+func (policy DownloadPolicy) CheckUrl(ctx context.Context, rawUrl string) error {
+  target, err := url.Parse(rawUrl)
+  if err != nil {
+    return rejectf("invalid url")
+  }
+
+  if target.Scheme != "http" && target.Scheme != "https" {
+    return rejectf("only http and https urls are supported")
+  }
+
+  if _, err := policy.lookupHost(ctx, target.Hostname()); err != nil {
+    return err
+  }
+
+  return nil
+}
+
+// Resolves the host after checking that it is allowed. Returns
+// only the addresses that may be connected to.
+func (policy DownloadPolicy) lookupHost(ctx context.Context, host string) ([]net.IP, error) {
+  host = strings.ToLower(strings.TrimSuffix(host, "."))
+  if host == "" {
+    return nil, rejectf("the url has no host")
+  }
+
+  if !policy.hostAllowed(host) {
+    return nil, rejectf("host %s is not allowed", host)
+  }
+
+  addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+  if err != nil {
+    return nil, err
+  }
+
+  var allowed []net.IP
+  for _, address := range addresses {
+    if policy.AllowPrivate || !isPrivateAddress(address.IP) {
+      allowed = append(allowed, address.IP)
+    }
+  }
+
+  if len(allowed) == 0 {
+    return nil, rejectf("host %s is not a public address", host)
+  }
+
+  return allowed, nil
+}
+
+func (policy DownloadPolicy) hostAllowed(host string) bool {
+  if len(policy.AllowedHosts) == 0 {
+    return true
+  }
+
+  for _, allowed := range policy.AllowedHosts {
+    allowed = strings.ToLower(allowed)
+    if host == allowed || strings.HasSuffix(host, "." + allowed) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// Returns a http client that only connects to addresses allowed by the policy.
+// The addresses are checked when connecting, so neither redirects nor dns
+// tricks can get around the policy.
+func (policy DownloadPolicy) Client() *http.Client {
+  dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+  transport := &http.Transport{
+    // a proxy would connect to the addresses for us, bypassing the checks
+    Proxy: nil,
+
+    DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+      host, port, err := net.SplitHostPort(address)
+      if err != nil {
+        return nil, err
+      }
+
+      ips, err := policy.lookupHost(ctx, host)
+      if err != nil {
+        return nil, err
+      }
+
+      return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+    },
+
+    TLSHandshakeTimeout:   10 * time.Second,
+    ResponseHeaderTimeout: 30 * time.Second,
+    DisableKeepAlives:     true,
+  }
+
+  return &http.Client{Transport: transport}
+}
+
+// Wraps the body of a download and fails once more than the allowed number of bytes was read.
+func (policy DownloadPolicy) limitBody(body io.Reader) io.Reader {
+  if policy.MaxSize <= 0 {
+    return body
+  }
+
+  return &limitedReader{reader: body, remaining: policy.MaxSize, limit: policy.MaxSize}
+}
+
+type limitedReader struct {
+  reader    io.Reader
+  remaining int64
+  limit     int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+  if r.remaining < 0 {
+    return 0, rejectf("the file is larger than %d MB", r.limit / 1024 / 1024)
+  }
+
+  // read one more byte than allowed, so we notice if the file is too large.
+  if int64(len(p)) > r.remaining + 1 {
+    p = p[:r.remaining + 1]
+  }
+
+  n, err := r.reader.Read(p)
+  r.remaining -= int64(n)
+  if r.remaining < 0 {
+    return n, rejectf("the file is larger than %d MB", r.limit / 1024 / 1024)
+  }
+
+  return n, err
+}
@@ -0,0 +1,143 @@
+package job
+
+import (
+  "database/sql"
+  "encoding/json"
+  "time"
+
+  "github.com/jmoiron/sqlx"
+  "github.com/pkg/errors"
+)
+
+const postgresSchema = `
+  CREATE TABLE IF NOT EXISTS export_job (
+    id          TEXT PRIMARY KEY,
+    project     JSONB NOT NULL,
+    status      TEXT NOT NULL,
+    stage       TEXT NOT NULL DEFAULT '',
+    error       TEXT NOT NULL DEFAULT '',
+    output_file TEXT NOT NULL DEFAULT '',
+    created     TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated     TIMESTAMP WITH TIME ZONE NOT NULL
+  )`
+
+// Persists jobs in a postgres database. Jobs of this process are kept in
+// memory too, so their progress can be reported while they are running.
+type PostgresJobStore struct {
+  db     *sqlx.DB
+  active *JobManager
+}
+
+type jobRow struct {
+  Id         string    `db:"id"`
+  Project    []byte    `db:"project"`
+  Status     string    `db:"status"`
+  Stage      string    `db:"stage"`
+  Error      string    `db:"error"`
+  OutputFile string    `db:"output_file"`
+  Created    time.Time `db:"created"`
+  Updated    time.Time `db:"updated"`
+}
+
+func NewPostgresJobStore(db *sqlx.DB) (*PostgresJobStore, error) {
+  if _, err := db.Exec(postgresSchema); err != nil {
+    return nil, errors.WithMessage(err, "Could not create job table")
+  }
+
+  return &PostgresJobStore{db: db, active: NewJobManager()}, nil
+}
+
+func (store *PostgresJobStore) Put(job *Job) error {
+  store.active.Put(job)
+
+  projectJSON, err := json.Marshal(job.Project)
+  if err != nil {
+    return errors.WithMessage(err, "Could not encode project")
+  }
+
+  row := jobRow{
+    Id:         job.Id,
+    Project:    projectJSON,
+    Status:     string(job.Status()),
+    Stage:      string(job.Stage()),
+    OutputFile: job.OutputFile,
+    Created:    job.Created,
+    Updated:    time.Now(),
+  }
+
+  if err := job.Error(); err != nil {
+    row.Error = err.Error()
+  }
+
+  _, err = store.db.NamedExec(`
+    INSERT INTO export_job (id, project, status, stage, error, output_file, created, updated)
+    VALUES (:id, :project, :status, :stage, :error, :output_file, :created, :updated)
+    ON CONFLICT (id) DO UPDATE SET
+      status = EXCLUDED.status, stage = EXCLUDED.stage, error = EXCLUDED.error,
+      output_file = EXCLUDED.output_file, updated = EXCLUDED.updated`, row)
+
+  return errors.WithMessage(err, "Could not store job")
+}
+
+func (store *PostgresJobStore) Get(id string) (*Job, error) {
+  if job, _ := store.active.Get(id); job != nil {
+    return job, nil
+  }
+
+  var row jobRow
+  err := store.db.Get(&row, "SELECT * FROM export_job WHERE id=$1", id)
+  if err == sql.ErrNoRows {
+    return nil, nil
+  }
+
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not query job")
+  }
+
+  return row.toJob()
+}
+
+func (store *PostgresJobStore) Expire(id string) error {
+  store.active.Expire(id)
+
+  _, err := store.db.Exec("UPDATE export_job SET status=$2, output_file='', updated=now() WHERE id=$1", id, StatusExpired)
+  return errors.WithMessage(err, "Could not expire job")
+}
+
+func (store *PostgresJobStore) Interrupted() ([]*Job, error) {
+  var rows []jobRow
+  err := store.db.Select(&rows, "SELECT * FROM export_job WHERE status IN ($1, $2)", StatusQueued, StatusRunning)
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not query interrupted jobs")
+  }
+
+  var jobs []*Job
+  for _, row := range rows {
+    job, err := row.toJob()
+    if err != nil {
+      return nil, err
+    }
+
+    job.requeue()
+    store.active.Put(job)
+
+    jobs = append(jobs, job)
+  }
+
+  return jobs, nil
+}
+
+func (store *PostgresJobStore) Active() ([]*Job, error) {
+  return store.active.Active()
+}
+
+// Restores a job from its database representation.
+func (row jobRow) toJob() (*Job, error) {
+  var project Project
+  if err := json.Unmarshal(row.Project, &project); err != nil {
+    return nil, errors.WithMessage(err, "Could not decode project of job " + row.Id)
+  }
+
+  return restoreJob(row.Id, project, Status(row.Status), Stage(row.Stage),
+    row.Error, row.OutputFile, row.Created), nil
+}
@@ -0,0 +1,271 @@
+package job
+
+import (
+  "sort"
+  "strconv"
+)
+
+// Video codecs we can encode to.
+const (
+  CodecH264 = "h264"
+  CodecVP9  = "vp9"
+  CodecAV1  = "av1"
+
+  // animated images without audio
+  CodecGIF  = "gif"
+  CodecWebP = "webp"
+)
+
+// Settings for encoding the exported video.
+type Profile struct {
+  Name         string `json:"name"`
+
+  // one of h264 (in mp4), vp9 or av1 (both in webm), gif or webp
+  Codec        string `json:"codec"`
+
+  // the video is scaled down to this width, keeping its aspect ratio.
+  MaxWidth     int    `json:"maxWidth"`
+
+  // limits the frame rate and the length in seconds of the output, if set.
+  MaxFrameRate float64 `json:"maxFrameRate,omitempty"`
+  MaxDuration  float64 `json:"maxDuration,omitempty"`
+
+  // target bitrate in kbit/s. A h264 video is encoded in two passes if set,
+  // otherwise in one pass with the constant rate factor. vp9 and av1
+  // are always encoded in two passes.
+  Bitrate      int    `json:"bitrate,omitempty"`
+  CRF          int    `json:"crf,omitempty"`
+
+  // x264 settings
+  Preset       string `json:"preset,omitempty"`
+  H264Profile  string `json:"h264Profile,omitempty"`
+  Level        string `json:"level,omitempty"`
+
+  // bitrate of the aac or opus audio in kbit/s. The audio of mp4
+  // videos is copied if zero and its codec fits into mp4.
+  AudioBitrate int    `json:"audioBitrate,omitempty"`
+
+  // quality of the webp images, from 0 to 100
+  Quality      int    `json:"quality,omitempty"`
+}
+
+const DefaultProfile = "pr0gramm-upload"
+
+var profiles = map[string]Profile{
+  "pr0gramm-upload": {
+    Name:        "pr0gramm-upload",
+    Codec:       CodecH264,
+    MaxWidth:    848,
+    Bitrate:     600,
+    Preset:      "medium",
+    H264Profile: "high",
+    Level:       "4.2",
+  },
+
+  "high-quality": {
+    Name:         "high-quality",
+    Codec:        CodecH264,
+    MaxWidth:     1920,
+    CRF:          18,
+    Preset:       "slow",
+    H264Profile:  "high",
+    Level:        "4.2",
+    AudioBitrate: 192,
+  },
+
+  "small-preview": {
+    Name:         "small-preview",
+    Codec:        CodecH264,
+    MaxWidth:     480,
+    CRF:          28,
+    Preset:       "veryfast",
+    H264Profile:  "main",
+    Level:        "3.1",
+    AudioBitrate: 96,
+  },
+
+  "webm-vp9": {
+    Name:         "webm-vp9",
+    Codec:        CodecVP9,
+    MaxWidth:     1280,
+    CRF:          32,
+    AudioBitrate: 96,
+  },
+
+  "webm-av1": {
+    Name:         "webm-av1",
+    Codec:        CodecAV1,
+    MaxWidth:     1280,
+    CRF:          34,
+    AudioBitrate: 96,
+  },
+
+  "gif": {
+    Name:         "gif",
+    Codec:        CodecGIF,
+    MaxWidth:     480,
+    MaxFrameRate: 15,
+    MaxDuration:  30,
+  },
+
+  "webp-animated": {
+    Name:         "webp-animated",
+    Codec:        CodecWebP,
+    MaxWidth:     640,
+    MaxFrameRate: 20,
+    MaxDuration:  30,
+    Quality:      75,
+  },
+}
+
+// Looks up a profile by its name. An empty name selects the default profile.
+func LookupProfile(name string) (Profile, bool) {
+  if name == "" {
+    name = DefaultProfile
+  }
+
+  profile, ok := profiles[name]
+  return profile, ok
+}
+
+// Returns all profiles ordered by their name.
+func Profiles() []Profile {
+  var result []Profile
+  for _, profile := range profiles {
+    result = append(result, profile)
+  }
+
+  sort.Slice(result, func(i, j int) bool {
+    return result[i].Name < result[j].Name
+  })
+
+  return result
+}
+
+// Returns the file extension of the container, including the dot.
+func (profile Profile) Extension() string {
+  switch profile.Codec {
+  case CodecVP9, CodecAV1:
+    return ".webm"
+
+  case CodecGIF:
+    return ".gif"
+
+  case CodecWebP:
+    return ".webp"
+  }
+
+  return ".mp4"
+}
+
+// Returns true if the profile produces an animated image. Those
+// can neither contain audio nor a subtitle track.
+func (profile Profile) IsAnimation() bool {
+  return profile.Codec == CodecGIF || profile.Codec == CodecWebP
+}
+
+// Returns the names of the passes to run, an empty name for single pass encoding.
+func (profile Profile) passes() []string {
+  if profile.Codec == CodecVP9 || profile.Codec == CodecAV1 || profile.Codec == CodecH264 && profile.Bitrate > 0 {
+    return []string{"1", "2"}
+  }
+
+  return []string{""}
+}
+
+// Returns the ffmpeg arguments to encode the video stream in the given pass.
+func (profile Profile) videoArgs(pass string) []string {
+  // the encoder uses a bitrate of zero to select constant quality mode
+  bitrate := strconv.Itoa(profile.Bitrate) + "k"
+
+  switch profile.Codec {
+  case CodecVP9:
+    // the first pass only collects statistics, so it can go faster
+    cpuUsed := "1"
+    if pass == "1" {
+      cpuUsed = "4"
+    }
+
+    return []string{"-codec:v", "libvpx-vp9", "-b:v", bitrate, "-crf", strconv.Itoa(profile.CRF),
+      "-deadline", "good", "-cpu-used", cpuUsed, "-row-mt", "1", "-pix_fmt", "yuv420p", "-pass", pass}
+
+  case CodecAV1:
+    return []string{"-codec:v", "libaom-av1", "-b:v", bitrate, "-crf", strconv.Itoa(profile.CRF),
+      "-cpu-used", "4", "-row-mt", "1", "-pix_fmt", "yuv420p", "-pass", pass}
+
+  case CodecGIF:
+    return []string{"-codec:v", "gif", "-loop", "0"}
+
+  case CodecWebP:
+    return []string{"-codec:v", "libwebp", "-quality", strconv.Itoa(profile.Quality),
+      "-compression_level", "6", "-loop", "0"}
+  }
+
+  args := []string{"-codec:v", "libx264", "-preset", profile.Preset,
+    "-profile:v", profile.H264Profile, "-level", profile.Level, "-pix_fmt", "yuv420p"}
+
+  if pass != "" {
+    return append(args, "-b:v", bitrate, "-pass", pass)
+  }
+
+  return append(args, "-crf", strconv.Itoa(profile.CRF))
+}
+
+// Returns the filters that take the rendered video from the input label
+// to the output label just before encoding.
+func (profile Profile) videoFilter(input, output string) string {
+  // cut the video before anything else, so no frames are
+  // processed or buffered that never make it into the output.
+  trim := "null"
+  if profile.MaxDuration > 0 {
+    trim = "trim=duration=" + strconv.FormatFloat(profile.MaxDuration, 'f', -1, 64) + ",setpts=PTS-STARTPTS"
+  }
+
+  if profile.Codec == CodecGIF {
+    // a gif has only 256 colors, so compute the best palette for the whole video first
+    return input + trim + ",split[frames][statistics];\n" +
+      "[statistics]palettegen=stats_mode=diff[palette];\n" +
+      "[frames][palette]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle" + output
+  }
+
+  return input + trim + output
+}
+
+// Audio codecs that can be copied into an mp4 file.
+var mp4AudioCodecs = []string{"aac", "mp3", "opus"}
+
+// Returns the ffmpeg arguments to encode the audio stream. The codec is the one of
+// the mapped input stream, empty if the audio is filtered. The audio is only copied
+// if the container supports its codec, otherwise it is encoded again.
+func (profile Profile) audioArgs(codec string) []string {
+  if profile.Extension() == ".webm" {
+    // webm only supports opus or vorbis, so we can never copy the audio
+    bitrate := profile.AudioBitrate
+    if bitrate <= 0 {
+      bitrate = 128
+    }
+
+    return []string{"-codec:a", "libopus", "-b:a", strconv.Itoa(bitrate) + "k"}
+  }
+
+  if profile.AudioBitrate > 0 {
+    return []string{"-codec:a", "aac", "-b:a", strconv.Itoa(profile.AudioBitrate) + "k"}
+  }
+
+  for _, supported := range mp4AudioCodecs {
+    if codec == supported {
+      return []string{"-codec:a", "copy"}
+    }
+  }
+
+  return []string{"-codec:a", "aac", "-b:a", "128k"}
+}
+
+// Returns the codec for the soft subtitle track.
+func (profile Profile) subtitleCodec() string {
+  if profile.Extension() == ".webm" {
+    return "webvtt"
+  }
+
+  return "mov_text"
+}
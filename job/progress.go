@@ -10,6 +10,9 @@ type ProgressUpdater func(current, total int)
 type Meter struct {
   steps    int
 
+  // called after every change of the progress
+  changed  func()
+
   lock     sync.Mutex
   progress float64
 }
@@ -33,9 +36,16 @@ func (pm *Meter) Progress() float64 {
 
 func (pm *Meter) FinishNow() {
   pm.lock.Lock()
-  defer pm.lock.Unlock()
-
   pm.progress = 1.0
+  pm.lock.Unlock()
+
+  pm.notify()
+}
+
+func (pm *Meter) notify() {
+  if pm.changed != nil {
+    pm.changed()
+  }
 }
 
 func (pm *Meter) Finished() bool {
@@ -45,8 +55,9 @@ func (pm *Meter) Finished() bool {
 func (pm *Meter) Step(n int) ProgressUpdater {
   return func(current, total int) {
     pm.lock.Lock()
-    defer pm.lock.Unlock()
-
     pm.progress = (float64(n) + float64(current) / math.Max(1, float64(total))) / math.Max(1, float64(pm.steps))
+    pm.lock.Unlock()
+
+    pm.notify()
   }
 }
@@ -6,7 +6,7 @@ import (
   "golang.org/x/image/font"
   "os"
   "image"
-  "image/jpeg"
+  "image/png"
   "github.com/disintegration/gift"
   "github.com/lucasb-eyer/go-colorful"
   "github.com/pkg/errors"
@@ -28,22 +28,10 @@ func LoadFontFace(filename string, fontSize float64) (font.Face, error) {
   }), nil
 }
 
-func RenderSubtitles(filename string, ff font.Face, fontSize float64, subtitles []Subtitle) error {
-  fp, err := os.OpenFile(filename, os.O_RDWR, 0644)
-  if err != nil {
-    return errors.WithMessage(err, "Could not open image file")
-  }
-
-  defer fp.Close()
-
-  bgImage, _, err := image.Decode(fp)
-  if err != nil {
-    return errors.WithMessage(err, "Could not read decode image")
-  }
-
+// Draws the subtitles onto a copy of the frame.
+func DrawSubtitles(frame image.Image, ff font.Face, fontSize float64, subtitles []Subtitle) *image.RGBA {
   // create a new blank canvas we can draw on
-  bounds := bgImage.Bounds()
-  textImage := image.NewRGBA(bounds)
+  textImage := image.NewRGBA(frame.Bounds())
 
   // font drawer for drawing and measuring
   drawer := font.Drawer{Dst: textImage, Face: ff}
@@ -54,27 +42,38 @@ func RenderSubtitles(filename string, ff font.Face, fontSize float64, subtitles
   }
 
   // compose images into target image.
-  targetImage := composeTargetImage(bgImage, textImage, fontSize);
+  return composeTargetImage(frame, textImage, fontSize)
+}
+
+// Renders the subtitles onto a transparent image of the given size
+// and writes it as a png file.
+func RenderOverlay(filename string, width, height int, ff font.Face, fontSize float64, subtitles []Subtitle) error {
+  bounds := image.Rect(0, 0, width, height)
+  textImage := image.NewRGBA(bounds)
 
-  // clean the file, so we can rewrite it with the new jpeg
-  if err := fp.Truncate(0); err != nil {
-    return errors.WithMessage(err, "Could not truncate the image file")
+  drawer := font.Drawer{Dst: textImage, Face: ff}
+  for _, subtitle := range subtitles {
+    renderSubtitle(subtitle, fontSize, drawer)
   }
 
-  if _, err := fp.Seek(0, os.SEEK_SET); err != nil {
-    return errors.WithMessage(err, "Could not reset the file pointer in the image file.")
+  // a fresh rgba image is fully transparent
+  targetImage := composeTargetImage(image.NewRGBA(bounds), textImage, fontSize)
+
+  fp, err := os.Create(filename)
+  if err != nil {
+    return errors.WithMessage(err, "Could not create overlay file")
   }
 
-  // now write the jpeg file
-  if err := jpeg.Encode(fp, targetImage, &jpeg.Options{Quality: 98}); err != nil {
-    return errors.WithMessage(err, "Could not encode the jpeg file.")
+  defer fp.Close()
+
+  if err := png.Encode(fp, targetImage); err != nil {
+    return errors.WithMessage(err, "Could not encode the png file.")
   }
 
-  // fine!
-  return nil
+  return errors.WithMessage(fp.Close(), "Could not write overlay file")
 }
 
-func composeTargetImage(bgImage image.Image, textImage image.Image, fontSize float64) image.Image {
+func composeTargetImage(bgImage image.Image, textImage image.Image, fontSize float64) *image.RGBA {
   bounds := bgImage.Bounds()
   targetImage := image.NewRGBA(bounds)
 
@@ -0,0 +1,141 @@
+package job
+
+import (
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "io"
+  "io/ioutil"
+  "os"
+  "regexp"
+  "strings"
+  "time"
+
+  "github.com/pkg/errors"
+)
+
+// Directory that contains the uploaded source videos, named by their id.
+const SourceRoot = "temp/sources"
+
+// Project.Video references an uploaded video with this prefix followed by its id.
+const SourcePrefix = "source:"
+
+// Returned if an uploaded file is larger than allowed.
+var ErrSourceTooLarge = errors.New("The file is too large")
+
+// Returned if an uploaded file is not a video or audio file ffmpeg can read.
+var ErrInvalidSource = errors.New("The file does not contain the expected video or audio")
+
+// What an uploaded file is used for.
+type SourceKind string
+
+const (
+  // the video of a project
+  SourceVideo SourceKind = "video"
+
+  // the audio track or the music of a project
+  SourceAudio SourceKind = "audio"
+)
+
+// Returns true if the file contains the streams needed for this kind of source.
+func (kind SourceKind) accepts(info *VideoInfo) bool {
+  if kind == SourceAudio {
+    return len(info.AudioStreams()) > 0
+  }
+
+  _, ok := info.VideoStream()
+  return ok
+}
+
+var sourceIdPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Stores an uploaded file of the given kind with at most maxSize bytes and returns its id, the
+// sha256 hash of its content. Uploading the same file twice returns the id of the existing file.
+func StoreSource(ctx context.Context, reader io.Reader, kind SourceKind, maxSize int64) (string, error) {
+  if err := os.MkdirAll(SourceRoot, 0755); err != nil {
+    return "", errors.WithMessage(err, "Could not create source directory")
+  }
+
+  fp, err := ioutil.TempFile(SourceRoot, "upload-")
+  if err != nil {
+    return "", errors.WithMessage(err, "Could not create source file")
+  }
+
+  defer os.Remove(fp.Name())
+  defer fp.Close()
+
+  // read one byte more than allowed, so we notice if the file is too large
+  hash := sha256.New()
+  written, err := io.Copy(io.MultiWriter(fp, hash), io.LimitReader(reader, maxSize + 1))
+  if err != nil {
+    return "", errors.WithMessage(err, "Could not receive the video")
+  }
+
+  if written > maxSize {
+    return "", ErrSourceTooLarge
+  }
+
+  if err := fp.Close(); err != nil {
+    return "", errors.WithMessage(err, "Could not write source file")
+  }
+
+  // check the file even if we already know it, it might have been uploaded as another kind.
+  info, err := ReadVideoInfo(ctx, fp.Name())
+  if err != nil {
+    return "", ErrInvalidSource
+  }
+
+  if !kind.accepts(info) || info.CheckFormat() != nil {
+    return "", ErrInvalidSource
+  }
+
+  id := hex.EncodeToString(hash.Sum(nil))
+  target := sourcePath(id)
+
+  if _, err := os.Stat(target); err == nil {
+    // we already know this file, keep it around a little longer.
+    now := time.Now()
+    return id, os.Chtimes(target, now, now)
+  }
+
+  return id, errors.WithMessage(os.Rename(fp.Name(), target), "Could not store source file")
+}
+
+// Returns the id of the uploaded video the url references, if any.
+func SourceIdOf(video string) (string, bool) {
+  if !strings.HasPrefix(video, SourcePrefix) {
+    return "", false
+  }
+
+  id := strings.TrimPrefix(video, SourcePrefix)
+  return id, sourceIdPattern.MatchString(id)
+}
+
+// Reads the video information of a project video, either
+// an uploaded video or a remote url.
+func ReadSourceInfo(ctx context.Context, video string) (*VideoInfo, error) {
+  if id, ok := SourceIdOf(video); ok {
+    return ReadVideoInfo(ctx, sourcePath(id))
+  }
+
+  return ReadRemoteVideoInfo(ctx, video)
+}
+
+// Returns true if an uploaded video with this id exists.
+func HasSource(id string) bool {
+  if !sourceIdPattern.MatchString(id) {
+    return false
+  }
+
+  _, err := os.Stat(sourcePath(id))
+  return err == nil
+}
+
+func sourcePath(id string) string {
+  return SourceRoot + "/" + id
+}
+
+// Copies the uploaded video into the target file.
+func copySource(id, target string) error {
+  return errors.WithMessage(linkOrCopy(sourcePath(id), target), "Could not copy source video")
+}
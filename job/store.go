@@ -0,0 +1,74 @@
+package job
+
+import (
+  "context"
+  "time"
+
+  "github.com/pkg/errors"
+)
+
+// Keeps track of jobs, so they can be looked up by their id.
+type JobStore interface {
+  // Stores the job or updates the already stored version of it.
+  Put(job *Job) error
+
+  // Returns the job with the given id, or nil, if there is no such job.
+  Get(id string) (*Job, error)
+
+  // Returns the jobs that were queued or running when the
+  // process stopped. They are reset, so they can be queued again.
+  Interrupted() ([]*Job, error)
+
+  // Returns the jobs of this process that are queued or running.
+  Active() ([]*Job, error)
+
+  // Marks the job as expired after its workspace was deleted. The store
+  // may forget about everything but the id and status of the job.
+  Expire(id string) error
+}
+
+// Creates a job from its stored representation.
+func restoreJob(id string, project Project, status Status, stage Stage, errorMessage, outputFile string, created time.Time) *Job {
+  job := &Job{
+    Id:         id,
+    Project:    project,
+    OutputFile: outputFile,
+    Created:    created,
+    status:     status,
+    stage:      stage,
+  }
+
+  job.ctx, job.cancel = context.WithCancel(context.Background())
+  job.Progress = job.newProgressMeter(1)
+
+  if errorMessage != "" {
+    job.error = errors.New(errorMessage)
+  }
+
+  if !isInterrupted(status) {
+    job.Progress.FinishNow()
+  }
+
+  return job
+}
+
+// Creates the placeholder of a job whose output is gone.
+func expiredJob(id string) *Job {
+  return restoreJob(id, Project{}, StatusExpired, "", "", "", time.Time{})
+}
+
+// Resets an interrupted job, so it can be executed again.
+func (job *Job) requeue() {
+  job.lock.Lock()
+  job.status = StatusQueued
+  job.stage = ""
+  job.error = nil
+  job.lock.Unlock()
+
+  job.Progress = job.newProgressMeter(1)
+  job.OutputFile = ""
+}
+
+func isInterrupted(status Status) bool {
+  return status == StatusQueued || status == StatusRunning
+}
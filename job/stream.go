@@ -0,0 +1,115 @@
+package job
+
+import (
+  "context"
+  "image"
+  "io"
+
+  "github.com/pkg/errors"
+  "golang.org/x/image/font"
+)
+
+// Returns the subtitles that are visible at the given time.
+func visibleSubtitles(subtitles []Subtitle, currentTime float64) []Subtitle {
+  var result []Subtitle
+  for _, subtitle := range subtitles {
+    if subtitle.Time <= currentTime && currentTime <= subtitle.Time + subtitle.Duration {
+      result = append(result, subtitle)
+    }
+  }
+
+  return result
+}
+
+// Decodes the original video into raw rgba frames, draws the visible subtitles
+// into each frame and writes the frames to the given writer. Progress is
+// reported based on the decoded time of the video.
+func (job *Job) streamFrames(workspace string, w io.Writer, width, height int, rate FrameRate, ff font.Face, fontSize float64, progress ProgressUpdater) error {
+  // stop the decoder if we can not write the frames anymore
+  ctx, cancel := context.WithCancel(job.ctx)
+  defer cancel()
+
+  var args []string
+  args = append(args, trimArgs(job.Project)...)
+  args = append(args, untrustedInput("original.mp4")...)
+  args = append(args, "-vf", sourceFilter(job.Project, width, height, rate),
+    "-an", "-f", "rawvideo", "-pix_fmt", "rgba", "-")
+
+  cmd, stderr := ffmpegCommand(ctx, workspace, progress, args...)
+
+  stdout, err := cmd.StdoutPipe()
+  if err != nil {
+    return errors.WithMessage(err, "Could not open pipe to ffmpeg")
+  }
+
+  if err := cmd.Start(); err != nil {
+    return errors.WithMessage(err, "Could not start ffmpeg")
+  }
+
+  frame := image.NewRGBA(image.Rect(0, 0, width, height))
+
+  var streamErr error
+  for idx := 0; ; idx++ {
+    if _, err := io.ReadFull(stdout, frame.Pix); err != nil {
+      if err != io.EOF {
+        streamErr = errors.WithMessage(err, "Could not read frame from ffmpeg")
+      }
+
+      break
+    }
+
+    output := frame
+    if subtitles := visibleSubtitles(job.Project.Subtitles, rate.TimeOf(idx)); len(subtitles) > 0 {
+      output = DrawSubtitles(frame, ff, fontSize, subtitles)
+    }
+
+    if _, err := w.Write(output.Pix); err != nil {
+      streamErr = errors.WithMessage(err, "Could not write frame to encoder")
+      break
+    }
+  }
+
+  if streamErr != nil {
+    cancel()
+  }
+
+  if err := cmd.Wait(); err != nil && streamErr == nil {
+    streamErr = errors.WithMessage(err, "Could not decode video: " + stderr.String())
+  }
+
+  if progress != nil {
+    progress(1, 1)
+  }
+
+  return streamErr
+}
+
+// Runs the encoder while a second goroutine streams the frames into its stdin.
+func (job *Job) encodeStream(workspace string, input encoderInput, progress ProgressUpdater, args ...string) error {
+  reader, writer := io.Pipe()
+
+  streamed := make(chan error, 1)
+  go func() {
+    err := input.Frames(writer, progress)
+    writer.CloseWithError(err)
+    streamed <- err
+  }()
+
+  err := FFmpegWithInput(job.ctx, workspace, nil, reader, args...)
+
+  // unblock the streaming goroutine if the encoder stopped early
+  reader.Close()
+
+  streamErr := <-streamed
+  if err == nil && errors.Cause(streamErr) == io.ErrClosedPipe {
+    // the encoder did not need all frames, e.g. because of -shortest
+    streamErr = nil
+  }
+
+  // errors while decoding are more helpful than a broken pipe in the encoder
+  if streamErr != nil {
+    return streamErr
+  }
+
+  return err
+}
@@ -0,0 +1,31 @@
+package job
+
+import (
+  "io"
+  "os"
+
+  "github.com/pkg/errors"
+)
+
+// Writes the subtitles as a SubRip file that ffmpeg can mux as a subtitle track.
+// The subtitle formats live in package subformat, which sets this on startup.
+var WriteSubtitleTrack func(w io.Writer, subtitles []Subtitle) error
+
+func writeSubtitleTrack(filename string, subtitles []Subtitle) error {
+  if WriteSubtitleTrack == nil {
+    return errors.New("No writer for subtitle tracks")
+  }
+
+  fp, err := os.Create(filename)
+  if err != nil {
+    return errors.WithMessage(err, "Could not create subtitle file")
+  }
+
+  defer fp.Close()
+
+  if err := WriteSubtitleTrack(fp, subtitles); err != nil {
+    return err
+  }
+
+  return errors.WithMessage(fp.Close(), "Could not close subtitle file")
+}
@@ -0,0 +1,216 @@
+package job
+
+import (
+  "context"
+  "bytes"
+  "os/exec"
+  "os"
+  "encoding/json"
+  "math"
+  "strconv"
+  "strings"
+  "io"
+  "github.com/pkg/errors"
+)
+
+// Information about a media file as reported by ffprobe.
+type VideoInfo struct {
+  Streams []Stream `json:"streams"`
+
+  Format  struct {
+    // names of the demuxer, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+    Name     string `json:"format_name"`
+    Duration string `json:"duration"`
+    Size     string `json:"size"`
+  } `json:"format"`
+}
+
+type Stream struct {
+  Index  int    `json:"index"`
+
+  // one of video, audio, subtitle, data or attachment
+  Type   string `json:"codec_type"`
+  Codec  string `json:"codec_name"`
+
+  Width  int    `json:"width"`
+  Height int    `json:"height"`
+
+  RealFrameRate    string `json:"r_frame_rate"`
+  AverageFrameRate string `json:"avg_frame_rate"`
+
+  Disposition struct {
+    Default     int `json:"default"`
+    AttachedPic int `json:"attached_pic"`
+  } `json:"disposition"`
+
+  // older versions of ffmpeg report the rotation as a tag, newer ones as side data.
+  Tags struct {
+    Rotate string `json:"rotate"`
+  } `json:"tags"`
+
+  SideData []struct {
+    Rotation float64 `json:"rotation"`
+  } `json:"side_data_list"`
+}
+
+// Returns the first real video stream. Cover images
+// attached to audio files are not counted as video.
+func (info *VideoInfo) VideoStream() (Stream, bool) {
+  for _, stream := range info.Streams {
+    if stream.Type == "video" && stream.Disposition.AttachedPic == 0 {
+      return stream, true
+    }
+  }
+
+  return Stream{}, false
+}
+
+// Returns all audio streams.
+func (info *VideoInfo) AudioStreams() []Stream {
+  var result []Stream
+  for _, stream := range info.Streams {
+    if stream.Type == "audio" {
+      result = append(result, stream)
+    }
+  }
+
+  return result
+}
+
+// Returns the audio stream to export: the default one,
+// or the first audio stream if none is marked as default.
+func (info *VideoInfo) AudioStream() (Stream, bool) {
+  streams := info.AudioStreams()
+  for _, stream := range streams {
+    if stream.Disposition.Default != 0 {
+      return stream, true
+    }
+  }
+
+  if len(streams) > 0 {
+    return streams[0], true
+  }
+
+  return Stream{}, false
+}
+
+// Returns the duration of the file in seconds.
+func (info *VideoInfo) Duration() (float64, bool) {
+  duration, err := strconv.ParseFloat(info.Format.Duration, 64)
+  if err != nil || duration <= 0 {
+    return 0, false
+  }
+
+  return duration, true
+}
+
+// Returns the size of the file in bytes.
+func (info *VideoInfo) Size() (int64, bool) {
+  size, err := strconv.ParseInt(info.Format.Size, 10, 64)
+  return size, err == nil && size > 0
+}
+
+// Returns the size of the video as it is displayed, after applying the rotation.
+func (info *VideoInfo) Dimensions() (int, int, bool) {
+  stream, ok := info.VideoStream()
+  if !ok || stream.Width <= 0 || stream.Height <= 0 {
+    return 0, 0, false
+  }
+
+  if rotation := stream.Rotation(); rotation == 90 || rotation == 270 {
+    return stream.Height, stream.Width, true
+  }
+
+  return stream.Width, stream.Height, true
+}
+
+// Returns the frame rate of the video stream. The average frame rate is
+// preferred, as the real frame rate is often too high for variable frame rates.
+func (info *VideoInfo) FrameRate() (FrameRate, bool) {
+  stream, ok := info.VideoStream()
+  if !ok {
+    return FrameRate{}, false
+  }
+
+  if rate, ok := ParseFrameRate(stream.AverageFrameRate); ok {
+    return rate, true
+  }
+
+  return ParseFrameRate(stream.RealFrameRate)
+}
+
+// Returns the clockwise rotation of the stream in degrees, one of 0, 90, 180 or 270.
+// ffmpeg applies the rotation when decoding the video.
+func (stream Stream) Rotation() int {
+  var degrees float64
+  if rotate, err := strconv.ParseFloat(stream.Tags.Rotate, 64); err == nil {
+    degrees = rotate
+  } else {
+    // the display matrix rotates counter clockwise
+    for _, data := range stream.SideData {
+      if data.Rotation != 0 {
+        degrees = -data.Rotation
+        break
+      }
+    }
+  }
+
+  // normalize to a multiple of 90 degrees between 0 and 270
+  quarters := int(math.Floor(degrees / 90 + 0.5)) % 4
+  if quarters < 0 {
+    quarters += 4
+  }
+
+  return quarters * 90
+}
+
+// Demuxers ffmpeg may use to read files from the outside. Playlists like hls or
+// concat files are not allowed, as they make ffmpeg read other files and urls.
+var allowedFormats = []string{
+  "mov", "mp4", "m4a", "3gp", "matroska", "webm", "avi", "flv", "mpegts",
+  "mp3", "ogg", "wav", "flac", "aac",
+}
+
+// Returns the input options and the input for a file that came from
+// the outside, restricted to plain local files in the allowed formats.
+func untrustedInput(filename string) []string {
+  return []string{"-protocol_whitelist", "file,pipe", "-format_whitelist", strings.Join(allowedFormats, ","), "-i", filename}
+}
+
+// Checks that the file was read by one of the allowed demuxers.
+func (info *VideoInfo) CheckFormat() error {
+  for _, name := range strings.Split(info.Format.Name, ",") {
+    for _, allowed := range allowedFormats {
+      if name == allowed {
+        return nil
+      }
+    }
+  }
+
+  return rejectf("files of format %q are not supported", info.Format.Name)
+}
+
+func ReadVideoInfo(ctx context.Context, filename string) (*VideoInfo, error) {
+  args := []string{"-hide_banner", "-loglevel", "error", "-print_format", "json", "-show_streams", "-show_format"}
+  return readVideoInfo(ctx, nil, append(args, untrustedInput(filename)...)...)
+}
+
+// Runs ffprobe with the given arguments and stdin.
+func readVideoInfo(ctx context.Context, stdin io.Reader, args ...string) (*VideoInfo, error) {
+  var stdout bytes.Buffer
+  cmd := exec.CommandContext(ctx, "ffprobe", args...)
+
+  cmd.Stdin = stdin
+
+  cmd.Stdout = &stdout
+  cmd.Stderr = os.Stdout
+
+  if err := cmd.Run(); err != nil {
+    return nil, errors.WithMessage(err, "Could not run ffprobe")
+  }
+
+  // decode result as json
+  var result VideoInfo
+  err := json.NewDecoder(&stdout).Decode(&result)
+  return &result, errors.WithMessage(err, "Could not decode ffprobe output")
+}
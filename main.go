@@ -1,20 +1,41 @@
 package main
 
 import (
+  "flag"
   "net/http"
 
   "github.com/Sirupsen/logrus"
   "github.com/julienschmidt/httprouter"
 
   "math/rand"
+  "strings"
 
   "time"
 
   "github.com/mopsalarm/s0btitle/job"
   "github.com/mopsalarm/s0btitle/rest"
+  "github.com/mopsalarm/s0btitle/resolve"
+  "github.com/jmoiron/sqlx"
+  _ "github.com/lib/pq"
 )
 
 func main() {
+  postgres := flag.String("postgres", "", "Connection string of a postgres database to store jobs in.")
+  jobDirectory := flag.String("job-directory", "temp/jobs", "Directory to store jobs in, if no postgres database is set. Jobs are only kept in memory if empty.")
+  retention := flag.Duration("retention", 24 * time.Hour, "Delete rendered and uploaded videos after this time. Zero keeps them forever.")
+  diskBudget := flag.Int64("disk-budget", 0, "Delete the oldest rendered and uploaded videos if all of them take more than this number of megabytes. Zero means no limit.")
+  downloadCache := flag.String("download-cache", "temp/downloads", "Directory to keep downloaded videos in, so they are not downloaded again for every export. Disabled if empty.")
+  downloadCacheSize := flag.Int64("download-cache-size", 2048, "Delete the least recently used downloads if all of them take more than this number of megabytes. Zero means no limit.")
+  allowedHosts := flag.String("allowed-hosts", "", "Comma separated list of hosts to download videos from, including their subdomains. Any public host if empty.")
+  allowPrivate := flag.Bool("allow-private-sources", false, "Allow downloading videos from loopback and private network addresses.")
+  maxDownloadSize := flag.Int64("max-download-size", 512, "Maximum size of a downloaded video in megabytes. Zero means no limit.")
+  maxDuration := flag.Duration("max-duration", 10 * time.Minute, "Maximum duration of a video to export. Zero means no limit.")
+  downloadTimeout := flag.Duration("download-timeout", 5 * time.Minute, "Stop downloads that take longer than this. Zero means no limit.")
+  resolveCacheSize := flag.Int("resolve-cache-size", 1024, "Number of resolved videos to remember.")
+  resolveCacheTTL := flag.Duration("resolve-cache-ttl", 6 * time.Hour, "Resolve a video again after this time.")
+  resolveCacheFile := flag.String("resolve-cache-file", "temp/resolve-cache.json", "File to keep resolved videos in across restarts. Only kept in memory if empty.")
+  flag.Parse()
+
   // randomize!
   rand.Seed(time.Now().UnixNano())
 
@@ -23,13 +44,84 @@ func main() {
   router.ServeFiles("/frontend/*filepath", http.Dir("frontend/"))
   router.Handler("GET", "/", http.RedirectHandler("/frontend/", http.StatusTemporaryRedirect))
 
+  var jobs job.JobStore = job.NewJobManager()
+  if *postgres != "" {
+    db, err := sqlx.Connect("postgres", *postgres)
+    if err != nil {
+      logrus.Fatal("Could not connect to postgres: ", err)
+    }
+
+    jobs, err = job.NewPostgresJobStore(db)
+    if err != nil {
+      logrus.Fatal("Could not create job store: ", err)
+    }
+
+  } else if *jobDirectory != "" {
+    var err error
+    jobs, err = job.NewFileJobStore(*jobDirectory)
+    if err != nil {
+      logrus.Fatal("Could not create job store: ", err)
+    }
+  }
+
+  job.Limits = job.DownloadPolicy{
+    AllowPrivate: *allowPrivate,
+    MaxSize:      *maxDownloadSize * 1024 * 1024,
+    MaxDuration:  *maxDuration,
+    Timeout:      *downloadTimeout,
+  }
+
+  for _, host := range strings.Split(*allowedHosts, ",") {
+    if host = strings.TrimSpace(host); host != "" {
+      job.Limits.AllowedHosts = append(job.Limits.AllowedHosts, host)
+    }
+  }
+
+  if *downloadCache != "" {
+    var err error
+    job.Downloads, err = job.NewDownloadCache(*downloadCache, *downloadCacheSize * 1024 * 1024)
+    if err != nil {
+      logrus.Fatal("Could not create download cache: ", err)
+    }
+  }
+
+  // continue with jobs that were interrupted by the last shutdown
+  interrupted, err := jobs.Interrupted()
+  if err != nil {
+    logrus.Fatal("Could not look for interrupted jobs: ", err)
+  }
+
+  // urls without a video are looked up again a lot sooner
+  resolveCache := resolve.NewCache(*resolveCacheSize, *resolveCacheTTL, 10 * time.Minute)
+  if *resolveCacheFile != "" {
+    if err := resolveCache.Persist(*resolveCacheFile); err != nil {
+      logrus.Warn("Could not load resolve cache: ", err)
+    }
+  }
+
   jobChannel := make(chan *job.Job, 16)
 
-  rest.Setup(router, jobChannel)
+  rest.Setup(router, jobs, jobChannel, resolveCache)
 
   // start processing of jobs
   const concurrency = 2
-  go job.Execute(concurrency, jobChannel)
+  go job.Execute(concurrency, jobChannel, jobs)
+
+  // delete old workspaces
+  janitor := &job.Janitor{
+    Store:   jobs,
+    MaxAge:  *retention,
+    MaxSize: *diskBudget * 1024 * 1024,
+  }
+
+  go janitor.Run(10 * time.Minute)
+
+  go func() {
+    for _, interruptedJob := range interrupted {
+      logrus.WithField("id", interruptedJob.Id).Info("Queue interrupted job again")
+      jobChannel <- interruptedJob
+    }
+  }()
 
   logrus.Info("Starting http server on :8000")
   if err := http.ListenAndServe(":8000", router); err != nil {
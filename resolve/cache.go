@@ -0,0 +1,231 @@
+package resolve
+
+import (
+  "container/list"
+  "context"
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+
+  "github.com/Sirupsen/logrus"
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+// Changes are collected for this long before the cache file is written.
+const saveDelay = 10 * time.Second
+
+// Gives up resolving a url after this time. Lookups are shared between
+// requests, so they do not use the context of a single request.
+const resolveTimeout = 3 * time.Minute
+
+// Caches resolved videos by their url. Keeps at most Size entries and throws
+// away the least recently used ones first. Urls without a video are remembered
+// for a shorter time, other failures are not cached at all.
+type Cache struct {
+  size        int
+  ttl         time.Duration
+  negativeTTL time.Duration
+
+  // entries are persisted into this file, if set
+  filename    string
+
+  // true while a write of the cache file is scheduled
+  saving      bool
+
+  // only one write of the cache file at a time
+  saveLock    sync.Mutex
+
+  lock        sync.Mutex
+  entries     map[string]*list.Element
+  order       *list.List
+
+  // lookups currently in progress
+  calls       map[string]*cacheCall
+}
+
+type cacheEntry struct {
+  Url      string    `json:"url"`
+  Video    Video     `json:"video"`
+  NotFound bool      `json:"notFound"`
+  Expires  time.Time `json:"expires"`
+}
+
+// A lookup in progress. Callers for the same url wait for done to be closed.
+type cacheCall struct {
+  done  chan struct{}
+  video Video
+  err   error
+}
+
+func NewCache(size int, ttl, negativeTTL time.Duration) *Cache {
+  return &Cache{
+    size:        size,
+    ttl:         ttl,
+    negativeTTL: negativeTTL,
+    entries:     map[string]*list.Element{},
+    order:       list.New(),
+    calls:       map[string]*cacheCall{},
+  }
+}
+
+// Loads the entries stored in the file and keeps the file up to date from
+// now on. A missing file is fine, it is created on the first change.
+func (cache *Cache) Persist(filename string) error {
+  cache.lock.Lock()
+  defer cache.lock.Unlock()
+
+  cache.filename = filename
+
+  content, err := ioutil.ReadFile(filename)
+  if os.IsNotExist(err) {
+    return nil
+  }
+
+  if err != nil {
+    return errors.WithMessage(err, "Could not read resolve cache")
+  }
+
+  var stored []cacheEntry
+  if err := json.Unmarshal(content, &stored); err != nil {
+    return errors.WithMessage(err, "Could not decode resolve cache")
+  }
+
+  // entries are stored from the most to the least recently used
+  for idx := len(stored) - 1; idx >= 0; idx-- {
+    if entry := stored[idx]; time.Now().Before(entry.Expires) {
+      cache.add(entry)
+    }
+  }
+
+  return nil
+}
+
+// Resolves the url, or returns the cached result of an earlier call.
+// Concurrent calls for the same url share a single lookup.
+func (cache *Cache) Resolve(ctx context.Context, url string) (Video, error) {
+  cache.lock.Lock()
+
+  if element, ok := cache.entries[url]; ok {
+    entry := element.Value.(cacheEntry)
+    if time.Now().Before(entry.Expires) {
+      cache.order.MoveToFront(element)
+      cache.lock.Unlock()
+
+      if entry.NotFound {
+        return Video{}, ErrNotFound
+      }
+
+      return entry.Video, nil
+    }
+
+    cache.order.Remove(element)
+    delete(cache.entries, url)
+  }
+
+  call, running := cache.calls[url]
+  if !running {
+    call = &cacheCall{done: make(chan struct{})}
+    cache.calls[url] = call
+    go cache.lookup(url, call)
+  }
+
+  cache.lock.Unlock()
+
+  select {
+  case <-call.done:
+    return call.video, call.err
+
+  case <-ctx.Done():
+    return Video{}, ctx.Err()
+  }
+}
+
+func (cache *Cache) lookup(url string, call *cacheCall) {
+  ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+  defer cancel()
+
+  call.video, call.err = Resolve(ctx, url)
+
+  cache.lock.Lock()
+  defer cache.lock.Unlock()
+
+  delete(cache.calls, url)
+  close(call.done)
+
+  switch call.err {
+  case nil:
+    cache.add(cacheEntry{Url: url, Video: call.video, Expires: time.Now().Add(cache.ttl)})
+
+  case ErrNotFound:
+    cache.add(cacheEntry{Url: url, NotFound: true, Expires: time.Now().Add(cache.negativeTTL)})
+
+  default:
+    // might work the next time
+    return
+  }
+
+  cache.scheduleSave()
+}
+
+// Writes the cache file a moment from now, so a burst of
+// lookups results in a single write. Expects the lock to be held.
+func (cache *Cache) scheduleSave() {
+  if cache.filename == "" || cache.saving {
+    return
+  }
+
+  cache.saving = true
+  time.AfterFunc(saveDelay, func() {
+    if err := cache.save(); err != nil {
+      logrus.Warn("Could not persist resolve cache: ", err)
+    }
+  })
+}
+
+// Adds an entry as the most recently used one and evicts the least
+// recently used ones if the cache is full. Expects the lock to be held.
+func (cache *Cache) add(entry cacheEntry) {
+  if element, ok := cache.entries[entry.Url]; ok {
+    cache.order.Remove(element)
+  }
+
+  cache.entries[entry.Url] = cache.order.PushFront(entry)
+
+  for cache.size > 0 && cache.order.Len() > cache.size {
+    oldest := cache.order.Back()
+    cache.order.Remove(oldest)
+    delete(cache.entries, oldest.Value.(cacheEntry).Url)
+  }
+}
+
+// Writes all entries into the cache file. Only a snapshot of the entries
+// is taken while holding the lock, lookups are not blocked by the disk.
+func (cache *Cache) save() error {
+  cache.saveLock.Lock()
+  defer cache.saveLock.Unlock()
+
+  cache.lock.Lock()
+  cache.saving = false
+
+  var stored []cacheEntry
+  for element := cache.order.Front(); element != nil; element = element.Next() {
+    stored = append(stored, element.Value.(cacheEntry))
+  }
+
+  cache.lock.Unlock()
+
+  content, err := json.Marshal(stored)
+  if err != nil {
+    return errors.WithMessage(err, "Could not encode resolve cache")
+  }
+
+  if err := os.MkdirAll(filepath.Dir(cache.filename), 0755); err != nil {
+    return errors.WithMessage(err, "Could not create directory for resolve cache")
+  }
+
+  return errors.WithMessage(job.WriteFileAtomic(cache.filename, content), "Could not write resolve cache")
+}
@@ -0,0 +1,31 @@
+package resolve
+
+import (
+  "context"
+  "net/url"
+  "path"
+  "strings"
+)
+
+var videoExtensions = map[string]bool{
+  ".mp4":  true,
+  ".m4v":  true,
+  ".mov":  true,
+  ".webm": true,
+  ".mkv":  true,
+}
+
+// Resolves urls that point directly to a video file.
+type DirectResolver struct{}
+
+func (DirectResolver) Name() string {
+  return "direct"
+}
+
+func (DirectResolver) Matches(target *url.URL) bool {
+  return isWebUrl(target) && videoExtensions[strings.ToLower(path.Ext(target.Path))]
+}
+
+func (DirectResolver) Resolve(ctx context.Context, target *url.URL) (Video, error) {
+  return Video{Url: target.String(), Title: path.Base(target.Path)}, nil
+}
@@ -0,0 +1,118 @@
+package resolve
+
+import (
+  "context"
+  "fmt"
+  "html"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+// Only the head of a page is interesting, so we stop reading after this many bytes.
+const maxPageSize = 1024 * 1024
+
+var reMetaTag = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+var reMetaAttribute = regexp.MustCompile(`(?is)(property|name|content)\s*=\s*("[^"]*"|'[^']*')`)
+
+// Resolves html pages announcing their video with open graph tags, e.g. <meta property="og:video" content="...">.
+type PageResolver struct{}
+
+func (PageResolver) Name() string {
+  return "page"
+}
+
+func (PageResolver) Matches(target *url.URL) bool {
+  return isWebUrl(target)
+}
+
+func (PageResolver) Resolve(ctx context.Context, target *url.URL) (Video, error) {
+  req, err := http.NewRequest("GET", target.String(), nil)
+  if err != nil {
+    return Video{}, err
+  }
+
+  response, err := job.Limits.Client().Do(req.WithContext(ctx))
+  if err != nil {
+    return Video{}, errors.WithMessage(err, "Could not load page")
+  }
+
+  defer response.Body.Close()
+
+  if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+    return Video{}, ErrNotFound
+  }
+
+  // might work the next time, so this is not a missing video
+  if response.StatusCode != http.StatusOK {
+    return Video{}, fmt.Errorf("Page responded with status %d", response.StatusCode)
+  }
+
+  page, err := ioutil.ReadAll(io.LimitReader(response.Body, maxPageSize))
+  if err != nil {
+    return Video{}, errors.WithMessage(err, "Could not read page")
+  }
+
+  properties := openGraphProperties(string(page))
+
+  var video Video
+  for _, property := range []string{"og:video:secure_url", "og:video:url", "og:video"} {
+    if value := properties[property]; value != "" {
+      video.Url = value
+      break
+    }
+  }
+
+  if video.Url == "" {
+    return Video{}, ErrNotFound
+  }
+
+  // the video might be given relative to the page. Anything but a web url,
+  // e.g. file:// or one of ffmpegs own protocols, is not a video for us.
+  videoUrl, err := target.Parse(video.Url)
+  if err != nil || !isWebUrl(videoUrl) {
+    return Video{}, ErrNotFound
+  }
+
+  video.Url = videoUrl.String()
+
+  video.Title = properties["og:title"]
+  video.Width, _ = strconv.Atoi(properties["og:video:width"])
+  video.Height, _ = strconv.Atoi(properties["og:video:height"])
+
+  if thumbnail, err := target.Parse(properties["og:image"]); err == nil && properties["og:image"] != "" {
+    video.Thumbnail = thumbnail.String()
+  }
+
+  return video, nil
+}
+
+// Collects the content of the meta tags in the page by their property or name.
+// The first tag wins if a property is given more than once.
+func openGraphProperties(page string) map[string]string {
+  properties := map[string]string{}
+  for _, tag := range reMetaTag.FindAllString(page, -1) {
+    var key, content string
+    for _, match := range reMetaAttribute.FindAllStringSubmatch(tag, -1) {
+      value := html.UnescapeString(match[2][1:len(match[2]) - 1])
+      if strings.ToLower(match[1]) == "content" {
+        content = value
+      } else {
+        key = value
+      }
+    }
+
+    if _, exists := properties[key]; key != "" && !exists {
+      properties[key] = content
+    }
+  }
+
+  return properties
+}
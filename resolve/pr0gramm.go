@@ -0,0 +1,90 @@
+package resolve
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "regexp"
+  "strconv"
+
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+var rePr0grammItemId = regexp.MustCompile(`/(\d+)(?::comment\d+)?/?$`)
+
+// Resolves pr0gramm posts like https://pr0gramm.com/new/12345 using the items api.
+type Pr0grammResolver struct{}
+
+// Returns the url of the pr0gramm post with the given id.
+func Pr0grammUrl(id int) string {
+  return fmt.Sprintf("https://pr0gramm.com/new/%d", id)
+}
+
+func (Pr0grammResolver) Name() string {
+  return "pr0gramm"
+}
+
+func (Pr0grammResolver) Matches(target *url.URL) bool {
+  return isWebUrl(target) && (target.Host == "pr0gramm.com" || target.Host == "www.pr0gramm.com")
+}
+
+func (Pr0grammResolver) Resolve(ctx context.Context, target *url.URL) (Video, error) {
+  match := rePr0grammItemId.FindStringSubmatch(target.Path)
+  if match == nil {
+    return Video{}, ErrNotFound
+  }
+
+  id, err := strconv.Atoi(match[1])
+  if err != nil {
+    return Video{}, ErrNotFound
+  }
+
+  req, err := http.NewRequest("GET", fmt.Sprintf("https://pr0gramm.com/api/items/get?id=%d&flags=15", id), nil)
+  if err != nil {
+    return Video{}, err
+  }
+
+  response, err := job.Limits.Client().Do(req.WithContext(ctx))
+  if err != nil {
+    return Video{}, errors.WithMessage(err, "Could not lookup post")
+  }
+
+  defer response.Body.Close()
+
+  if response.StatusCode != http.StatusOK {
+    return Video{}, fmt.Errorf("pr0gramm responded with status %d", response.StatusCode)
+  }
+
+  var result struct {
+    Items []struct {
+      Id     int
+      Image  string
+      Thumb  string
+      Width  int
+      Height int
+      Audio  bool
+    }
+  }
+
+  if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+    return Video{}, errors.WithMessage(err, "Could not decode pr0gramm response")
+  }
+
+  for _, item := range result.Items {
+    if item.Id == id {
+      return Video{
+        Url:       "https://img.pr0gramm.com/" + item.Image,
+        Title:     "pr0gramm " + strconv.Itoa(id),
+        Thumbnail: "https://thumb.pr0gramm.com/" + item.Thumb,
+        Width:     item.Width,
+        Height:    item.Height,
+        Audio:     item.Audio,
+      }, nil
+    }
+  }
+
+  return Video{}, ErrNotFound
+}
@@ -0,0 +1,157 @@
+// Package resolve finds the video file behind the url of a post or page,
+// so it can be used as the video of a job.Project.
+package resolve
+
+import (
+  "context"
+  "net/url"
+  "strings"
+  "time"
+
+  "github.com/Sirupsen/logrus"
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+// Gives up probing a video after this time. Videos that do not have their
+// index at the start are downloaded completely, so this takes a while.
+const probeTimeout = 2 * time.Minute
+
+var (
+  // No resolver knows how to handle the url.
+  ErrUnsupported = errors.New("Unsupported url")
+
+  // The resolver understood the url, but there is no video behind it.
+  ErrNotFound = errors.New("No video found")
+)
+
+// A video found by a resolver.
+type Video struct {
+  // url of the video file, usable as the video of a project
+  Url      string `json:"url"`
+
+  // name of the resolver that found the video
+  Resolver string `json:"resolver"`
+
+  Title     string  `json:"title,omitempty"`
+  Thumbnail string  `json:"thumbnail,omitempty"`
+
+  Width     int     `json:"width,omitempty"`
+  Height    int     `json:"height,omitempty"`
+
+  // in seconds
+  Duration  float64 `json:"duration,omitempty"`
+  FrameRate float64 `json:"frameRate,omitempty"`
+  Audio     bool    `json:"audio"`
+
+  // size of the video file in bytes
+  Size      int64   `json:"size,omitempty"`
+}
+
+// Finds the video behind urls of one kind, e.g. the posts of one site.
+type Resolver interface {
+  Name() string
+
+  // Returns true if the resolver can handle the url.
+  Matches(target *url.URL) bool
+
+  Resolve(ctx context.Context, target *url.URL) (Video, error)
+}
+
+// Resolvers in the order they are asked, the more specific ones first.
+var resolvers = []Resolver{
+  SourceResolver{},
+  Pr0grammResolver{},
+  DirectResolver{},
+  PageResolver{},
+}
+
+// Adds a resolver that is asked before all registered ones.
+func Register(resolver Resolver) {
+  resolvers = append([]Resolver{resolver}, resolvers...)
+}
+
+// Finds the video behind the url using the first resolver that matches it.
+func Resolve(ctx context.Context, rawUrl string) (Video, error) {
+  target, err := url.Parse(strings.TrimSpace(rawUrl))
+  if err != nil {
+    return Video{}, ErrUnsupported
+  }
+
+  if isWebUrl(target) {
+    if err := job.Limits.CheckUrl(ctx, target.String()); err != nil {
+      return Video{}, err
+    }
+  }
+
+  for _, resolver := range resolvers {
+    if !resolver.Matches(target) {
+      continue
+    }
+
+    video, err := resolver.Resolve(ctx, target)
+    if err != nil {
+      return Video{}, err
+    }
+
+    video.Resolver = resolver.Name()
+
+    // not every site tells us everything about the video
+    if err := probe(ctx, &video); err != nil {
+      logrus.WithField("url", video.Url).Warn("Could not probe video: ", err)
+
+      // any page can announce a video, so only trust it if we could read it
+      if _, ok := resolver.(PageResolver); ok {
+        return Video{}, ErrNotFound
+      }
+    }
+
+    return video, nil
+  }
+
+  return Video{}, ErrUnsupported
+}
+
+// Fills in the details of the video as reported by ffprobe. Usually only
+// the start of the file is read, not the whole video.
+func probe(ctx context.Context, video *Video) error {
+  ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+  defer cancel()
+
+  info, err := job.ReadSourceInfo(ctx, video.Url)
+  if err != nil {
+    return err
+  }
+
+  if err := info.CheckFormat(); err != nil {
+    return err
+  }
+
+  if _, ok := info.VideoStream(); !ok {
+    return ErrNotFound
+  }
+
+  if width, height, ok := info.Dimensions(); ok {
+    video.Width, video.Height = width, height
+  }
+
+  if duration, ok := info.Duration(); ok {
+    video.Duration = duration
+  }
+
+  if rate, ok := info.FrameRate(); ok {
+    video.FrameRate = rate.Float()
+  }
+
+  if size, ok := info.Size(); ok {
+    video.Size = size
+  }
+
+  video.Audio = len(info.AudioStreams()) > 0
+  return nil
+}
+
+// Returns true for urls pointing to the web.
+func isWebUrl(target *url.URL) bool {
+  return (target.Scheme == "http" || target.Scheme == "https") && target.Host != ""
+}
@@ -0,0 +1,28 @@
+package resolve
+
+import (
+  "context"
+  "net/url"
+
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+// Resolves the ids of uploaded videos, e.g. source:<id>.
+type SourceResolver struct{}
+
+func (SourceResolver) Name() string {
+  return "source"
+}
+
+func (SourceResolver) Matches(target *url.URL) bool {
+  return target.Scheme + ":" == job.SourcePrefix
+}
+
+func (SourceResolver) Resolve(ctx context.Context, target *url.URL) (Video, error) {
+  id, ok := job.SourceIdOf(target.String())
+  if !ok || !job.HasSource(id) {
+    return Video{}, ErrNotFound
+  }
+
+  return Video{Url: job.SourcePrefix + id}, nil
+}
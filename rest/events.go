@@ -0,0 +1,81 @@
+package rest
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "time"
+
+  "github.com/julienschmidt/httprouter"
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+// Do not send more than a few updates per second, rendering
+// reports progress for every single frame.
+const eventInterval = 250 * time.Millisecond
+
+// Streams the status of a job as server-sent events until the job has finished.
+func handleExportEvents(jobs job.JobStore) httprouter.Handle {
+  return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+    foundJob, err := jobs.Get(params.ByName("id"))
+    if err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not look up job")
+      return
+    }
+
+    if foundJob == nil {
+      http.NotFound(w, req)
+      return
+    }
+
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+      WriteError(w, http.StatusInternalServerError, nil, "Streaming not supported")
+      return
+    }
+
+    // subscribe before sending the first event, so we do not miss any change.
+    changes, unsubscribe := foundJob.Changes()
+    defer unsubscribe()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    for {
+      status := jobStatusOf(foundJob)
+      if err := writeEvent(w, "status", status); err != nil {
+        return
+      }
+
+      flusher.Flush()
+
+      if status.Status != job.StatusQueued && status.Status != job.StatusRunning {
+        return
+      }
+
+      select {
+      case <-changes:
+      case <-req.Context().Done():
+        return
+      }
+
+      select {
+      case <-time.After(eventInterval):
+      case <-req.Context().Done():
+        return
+      }
+    }
+  }
+}
+
+func writeEvent(w http.ResponseWriter, event string, value interface{}) error {
+  data, err := json.Marshal(value)
+  if err != nil {
+    return err
+  }
+
+  _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+  return err
+}
@@ -2,78 +2,56 @@ package rest
 
 import (
   "net/http"
-  "fmt"
-  "io/ioutil"
-  "encoding/json"
-  "sync"
   "github.com/julienschmidt/httprouter"
   "strconv"
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/mopsalarm/s0btitle/resolve"
 )
 
-type lookupResponse struct {
-  Url string `json:"url"`
-}
-
-var resolveCache = map[int]lookupResponse{}
-var resolveCacheLock sync.Mutex
+// Resolves the pr0gramm post with the given id.
+func handleResolveVideoId(cache *resolve.Cache) httprouter.Handle {
+  return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+    id, err := strconv.Atoi(params.ByName("id"))
+    if err != nil {
+      WriteError(w, http.StatusBadRequest, err, "Could not parse id")
+      return
+    }
 
-func handleResolveVideoId(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-  id, err := strconv.Atoi(params.ByName("id"))
-  if err != nil {
-    WriteError(w, http.StatusBadRequest, err, "Could not parse id")
-    return
+    resolveVideo(w, req, cache, resolve.Pr0grammUrl(id))
   }
+}
 
-  resolveCacheLock.Lock()
-  cachedValue, cached := resolveCache[id]
-  resolveCacheLock.Unlock()
-
-  if cached {
-    r.JSON(w, http.StatusOK, cachedValue)
-    return
-  }
+// Resolves the url given in the query, e.g. /resolve?url=https://pr0gramm.com/new/1
+func handleResolveUrl(cache *resolve.Cache) httprouter.Handle {
+  return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+    url := req.URL.Query().Get("url")
+    if url == "" {
+      WriteError(w, http.StatusBadRequest, nil, "Missing url parameter")
+      return
+    }
 
-  response, err := http.Get(fmt.Sprintf("http://pr0gramm.com/api/items/get?id=%d&flags=15", id))
-  if err != nil {
-    WriteError(w, http.StatusInternalServerError, err, "Could not lookup post.")
-    return
+    resolveVideo(w, req, cache, url)
   }
+}
 
-  defer response.Body.Close()
-
-  bytes, err := ioutil.ReadAll(response.Body)
-  if err != nil {
-    WriteError(w, http.StatusInternalServerError, err, "Could not read response.")
+func resolveVideo(w http.ResponseWriter, req *http.Request, cache *resolve.Cache, url string) {
+  video, err := cache.Resolve(req.Context(), url)
+  if rejected, ok := err.(*job.RejectedError); ok {
+    WriteError(w, http.StatusForbidden, nil, rejected.Error())
     return
   }
 
-  var target struct {
-    Items []struct {
-      Id    int
-      Image string
-    }
-  }
+  switch err {
+  case nil:
+    r.JSON(w, http.StatusOK, video)
 
-  if err := json.Unmarshal(bytes, &target); err != nil {
-    WriteError(w, http.StatusInternalServerError, err, "Could not decode pr0gramm response.")
-    return
-  }
-
-  for _, item := range target.Items {
-    if item.Id == id {
-      result := lookupResponse{
-        Url: fmt.Sprintf("https://img.pr0gramm.com/%s", item.Image),
-      }
+  case resolve.ErrUnsupported:
+    WriteError(w, http.StatusBadRequest, nil, "Can not resolve videos from this url.")
 
-      // cache the value for next time
-      resolveCacheLock.Lock()
-      resolveCache[id] = result
-      resolveCacheLock.Unlock()
+  case resolve.ErrNotFound:
+    WriteError(w, http.StatusNotFound, nil, "No video found.")
 
-      r.JSON(w, http.StatusOK, result)
-      return
-    }
+  default:
+    WriteError(w, http.StatusBadGateway, err, "Could not resolve video.")
   }
-
-  WriteError(w, http.StatusNotFound, nil, "No post with this id found.")
 }
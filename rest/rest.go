@@ -4,60 +4,165 @@ import (
   "net/http"
   "github.com/julienschmidt/httprouter"
   "regexp"
+  "path/filepath"
   "encoding/json"
   "github.com/mopsalarm/s0btitle/job"
+  "github.com/mopsalarm/s0btitle/resolve"
   "github.com/unrolled/render"
 )
 
 var r *render.Render = render.New()
 
-func Setup(router *httprouter.Router, jobChannel chan <- *job.Job) {
-  jobs := job.NewJobManager()
-
+func Setup(router *httprouter.Router, jobs job.JobStore, jobChannel chan <- *job.Job, resolveCache *resolve.Cache) {
   router.POST("/api/export", handleExportVideo(jobs, jobChannel))
   router.GET("/api/export/:id", handleExportStatus(jobs))
-  router.GET("/video/:id/video.mp4", handleDownloadVideo)
-
-  router.GET("/resolve/:id", handleResolveVideoId)
+  router.GET("/api/export/:id/events", handleExportEvents(jobs))
+  router.DELETE("/api/export/:id", handleCancelExport(jobs))
+  router.POST("/api/export/:format", handleExportSubtitles)
+  router.POST("/api/import/:format", handleImportSubtitles)
+  router.POST("/api/upload", handleUploadVideo)
+  router.GET("/api/profiles", handleListProfiles)
+  router.GET("/video/:id/:filename", handleDownloadVideo(jobs))
+
+  router.GET("/resolve", handleResolveUrl(resolveCache))
+  router.GET("/resolve/:id", handleResolveVideoId(resolveCache))
 }
 
 type JobStatus struct {
-  Id       string  `json:"id"`
-  Finished bool    `json:"finished"`
-  Progress float64 `json:"progress"`
+  Id       string     `json:"id"`
+  Status   job.Status `json:"status"`
+  Stage    job.Stage  `json:"stage,omitempty"`
+  Error    string     `json:"error,omitempty"`
+  Finished bool       `json:"finished"`
+  Progress float64    `json:"progress"`
+
+  // path of the video, relative to the root of the server.
+  Video    string     `json:"video,omitempty"`
 }
 
-func handleExportStatus(manager *job.JobManager) httprouter.Handle {
+var contentTypes = map[string]string{
+  ".mp4":  "video/mp4",
+  ".webm": "video/webm",
+  ".gif":  "image/gif",
+  ".webp": "image/webp",
+}
+
+func handleListProfiles(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+  r.JSON(w, http.StatusOK, job.Profiles())
+}
+
+func handleExportStatus(jobs job.JobStore) httprouter.Handle {
   return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
     id := params.ByName("id")
 
-    foundJob := manager.Get(id)
+    foundJob, err := jobs.Get(id)
+    if err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not look up job")
+      return
+    }
+
+    if foundJob == nil {
+      http.NotFound(w, req)
+      return
+    }
+
+    r.JSON(w, http.StatusOK, jobStatusOf(foundJob))
+  }
+}
+
+func handleCancelExport(jobs job.JobStore) httprouter.Handle {
+  return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+    foundJob, err := jobs.Get(params.ByName("id"))
+    if err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not look up job")
+      return
+    }
+
     if foundJob == nil {
       http.NotFound(w, req)
       return
     }
 
-    r.JSON(w, http.StatusOK, JobStatus{
-      Id:       foundJob.Id,
-      Finished: foundJob.Finished(),
-      Progress: foundJob.Progress.Progress(),
-    })
+    if !foundJob.Cancel() {
+      r.JSON(w, http.StatusConflict, jobStatusOf(foundJob))
+      return
+    }
+
+    if err := jobs.Put(foundJob); err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not store job")
+      return
+    }
+
+    r.JSON(w, http.StatusOK, jobStatusOf(foundJob))
   }
 }
 
-func handleDownloadVideo(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-  id := params.ByName("id")
+func jobStatusOf(foundJob *job.Job) JobStatus {
+  status := JobStatus{
+    Id:       foundJob.Id,
+    Status:   foundJob.Status(),
+    Stage:    foundJob.Stage(),
+    Finished: foundJob.Finished(),
+    Progress: foundJob.Progress.Progress(),
+  }
+
+  if err := foundJob.Error(); err != nil {
+    status.Error = err.Error()
+  }
 
-  if !regexp.MustCompile("^[a-zA-Z]+$").MatchString(id) {
-    http.Error(w, "Invalid id.", http.StatusForbidden)
-    return
+  if status.Status == job.StatusDone {
+    status.Video = "video/" + foundJob.Id + "/video" + outputExtension(foundJob)
   }
 
-  // serve file, hopefully it is still there.
-  http.ServeFile(w, req, "temp/export/" + id + "/rendered.mp4")
+  return status
 }
 
-func handleExportVideo(jobs *job.JobManager, jobChannel chan <- *job.Job) httprouter.Handle {
+func handleDownloadVideo(jobs job.JobStore) httprouter.Handle {
+  return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+    id := params.ByName("id")
+
+    if !regexp.MustCompile("^[a-zA-Z]+$").MatchString(id) {
+      http.Error(w, "Invalid id.", http.StatusForbidden)
+      return
+    }
+
+    foundJob, err := jobs.Get(id)
+    if err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not look up job")
+      return
+    }
+
+    if foundJob != nil && foundJob.Status() == job.StatusExpired {
+      http.Error(w, "The video has expired.", http.StatusGone)
+      return
+    }
+
+    // redirect to the name with the extension of the actual file
+    extension := outputExtension(foundJob)
+    if filename := "video" + extension; params.ByName("filename") != filename {
+      http.Redirect(w, req, filename, http.StatusMovedPermanently)
+      return
+    }
+
+    w.Header().Set("Content-Type", contentTypes[extension])
+
+    // serve file, hopefully it is still there.
+    http.ServeFile(w, req, job.WorkspaceRoot + "/" + id + "/rendered" + extension)
+  }
+}
+
+// Returns the extension of the rendered video. Falls back to .mp4 for jobs we do not know anymore.
+func outputExtension(foundJob *job.Job) string {
+  if foundJob != nil {
+    if extension := filepath.Ext(foundJob.OutputFile); contentTypes[extension] != "" {
+      return extension
+    }
+  }
+
+  return ".mp4"
+}
+
+func handleExportVideo(jobs job.JobStore, jobChannel chan <- *job.Job) httprouter.Handle {
   return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
     var project job.Project
     if err := json.NewDecoder(req.Body).Decode(&project); err != nil {
@@ -65,9 +170,47 @@ func handleExportVideo(jobs *job.JobManager, jobChannel chan <- *job.Job) httpro
       return
     }
 
+    if _, ok := job.LookupProfile(project.Profile); !ok {
+      WriteError(w, http.StatusBadRequest, nil, "Unknown output profile")
+      return
+    }
+
+    if err := project.ValidateTiming(); err != nil {
+      WriteError(w, http.StatusBadRequest, err, "Invalid trim or speed")
+      return
+    }
+
+    if err := project.Audio.Validate(); err != nil {
+      WriteError(w, http.StatusBadRequest, err, "Invalid audio options")
+      return
+    }
+
+    // fail early instead of queueing a job that can never download its inputs
+    inputs := []string{project.Video}
+    for _, input := range []string{project.Audio.Track, project.Audio.Music} {
+      if input != "" {
+        inputs = append(inputs, input)
+      }
+    }
+
+    for _, input := range inputs {
+      if _, ok := job.SourceIdOf(input); ok {
+        continue
+      }
+
+      if err := job.Limits.CheckUrl(req.Context(), input); err != nil {
+        WriteError(w, http.StatusForbidden, nil, err.Error())
+        return
+      }
+    }
+
     job := job.NewJob(project)
 
-    jobs.Put(job)
+    if err := jobs.Put(job); err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not store job")
+      return
+    }
+
     jobChannel <- job
 
     r.JSON(w, http.StatusOK, map[string]string{"jobId": job.Id})
@@ -0,0 +1,68 @@
+package rest
+
+import (
+  "net/http"
+  "encoding/json"
+  "regexp"
+  "github.com/julienschmidt/httprouter"
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/mopsalarm/s0btitle/subformat"
+)
+
+type importResponse struct {
+  Subtitles []job.Subtitle `json:"subtitles"`
+  Dropped   []string       `json:"dropped"`
+}
+
+// Converts an uploaded subtitle file into a list of subtitles.
+func handleImportSubtitles(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+  format, ok := subformat.Lookup(params.ByName("format"))
+  if !ok || format.Decode == nil {
+    WriteError(w, http.StatusNotFound, nil, "Unknown subtitle format")
+    return
+  }
+
+  subtitles, dropped, err := format.Decode(req.Body)
+  if err != nil {
+    WriteError(w, http.StatusBadRequest, err, "Could not parse subtitle file")
+    return
+  }
+
+  if subtitles == nil {
+    subtitles = []job.Subtitle{}
+  }
+
+  if dropped == nil {
+    dropped = []string{}
+  }
+
+  r.JSON(w, http.StatusOK, importResponse{Subtitles: subtitles, Dropped: dropped})
+}
+
+// Converts the subtitles of a project into a subtitle file.
+func handleExportSubtitles(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+  format, ok := subformat.Lookup(params.ByName("format"))
+  if !ok || format.Encode == nil {
+    WriteError(w, http.StatusNotFound, nil, "Unknown subtitle format")
+    return
+  }
+
+  var project job.Project
+  if err := json.NewDecoder(req.Body).Decode(&project); err != nil {
+    WriteError(w, http.StatusBadRequest, err, "Could not decode body")
+    return
+  }
+
+  filename := "subtitles" + format.Extension
+  if regexp.MustCompile("^[a-zA-Z0-9_-]+$").MatchString(project.Id) {
+    filename = project.Id + format.Extension
+  }
+
+  w.Header().Set("Content-Type", format.ContentType)
+  w.Header().Set("Content-Disposition", "attachment; filename=\"" + filename + "\"")
+
+  if err := format.Encode(w, project); err != nil {
+    WriteError(w, http.StatusInternalServerError, err, "Could not write subtitle file")
+    return
+  }
+}
@@ -0,0 +1,70 @@
+package rest
+
+import (
+  "io"
+  "net/http"
+
+  "github.com/julienschmidt/httprouter"
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+// Uploads larger than this are rejected.
+const maxUploadSize = 512 * 1024 * 1024
+
+type uploadResponse struct {
+  Id    string `json:"id"`
+
+  // use this as the video, audio track or music of a project
+  Video string `json:"video"`
+}
+
+// Stores a video uploaded as the "video" field, or an audio file uploaded
+// as the "audio" field of a multipart form.
+func handleUploadVideo(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+  // leave some room for the rest of the form, the video itself is limited below
+  req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize + 1024 * 1024)
+
+  multipart, err := req.MultipartReader()
+  if err != nil {
+    WriteError(w, http.StatusBadRequest, err, "Expected a multipart form")
+    return
+  }
+
+  for {
+    part, err := multipart.NextPart()
+    if err == io.EOF {
+      break
+    }
+
+    if err != nil {
+      WriteError(w, http.StatusBadRequest, err, "Could not read upload")
+      return
+    }
+
+    kind := job.SourceKind(part.FormName())
+    if kind != job.SourceVideo && kind != job.SourceAudio {
+      continue
+    }
+
+    id, err := job.StoreSource(req.Context(), part, kind, maxUploadSize)
+    if err == job.ErrSourceTooLarge {
+      WriteError(w, http.StatusRequestEntityTooLarge, nil, err.Error())
+      return
+    }
+
+    if err == job.ErrInvalidSource {
+      WriteError(w, http.StatusBadRequest, nil, err.Error())
+      return
+    }
+
+    if err != nil {
+      WriteError(w, http.StatusInternalServerError, err, "Could not store video")
+      return
+    }
+
+    r.JSON(w, http.StatusOK, uploadResponse{Id: id, Video: job.SourcePrefix + id})
+    return
+  }
+
+  WriteError(w, http.StatusBadRequest, nil, "No video or audio in upload")
+}
@@ -0,0 +1,407 @@
+package subformat
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+var reAssOverride = regexp.MustCompile(`\{[^}]*\}`)
+var reAssColor = regexp.MustCompile(`(?i)^&?H?([0-9a-f]{1,8})&?$`)
+
+// Names of the override tags, longer names first so that "\fscx" is not read
+// as "\fs" and "\clip" is not read as "\c".
+var reAssTag = regexp.MustCompile(`^(1c|2c|3c|4c|1a|2a|3a|4a|alpha|an|a|clip|c|pos|move|org|` +
+  `fscx|fscy|fsp|fs|fn|fe|frx|fry|frz|fr|fade|fad|xbord|ybord|bord|xshad|yshad|shad|` +
+  `blur|be|b|iclip|i|u|s|kf|ko|k|K|q|pbo|p|t|r)(.*)$`)
+
+type assStyle struct {
+  Color     string
+  Alignment int
+}
+
+type assReader struct {
+  legacy   bool
+  playResX float64
+  playResY float64
+
+  styleFormat []string
+  eventFormat []string
+  styles      map[string]assStyle
+
+  subtitles []job.Subtitle
+  dropped   map[string]bool
+}
+
+// Reads subtitles from an Advanced SubStation Alpha (.ass) or SubStation Alpha (.ssa)
+// file. Colors and alignments of the styles and the override tags are mapped onto
+// the subtitles. Everything else the renderer can not reproduce (fonts, borders,
+// karaoke, animations, ...) is dropped and the names of those tags are returned.
+func ReadASS(r io.Reader) ([]job.Subtitle, []string, error) {
+  reader := &assReader{
+    playResX: 384,
+    playResY: 288,
+    styles:   make(map[string]assStyle),
+    dropped:  make(map[string]bool),
+  }
+
+  var section string
+
+  scanner := bufio.NewScanner(r)
+  for lineNumber := 1; scanner.Scan(); lineNumber++ {
+    line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+    if line == "" || strings.HasPrefix(line, ";") {
+      continue
+    }
+
+    if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+      section = strings.ToLower(line)
+      if section == "[v4 styles]" {
+        reader.legacy = true
+      }
+
+      continue
+    }
+
+    parts := strings.SplitN(line, ":", 2)
+    if len(parts) != 2 {
+      continue
+    }
+
+    key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+    var err error
+    switch section {
+    case "[script info]":
+      reader.readScriptInfo(key, value)
+
+    case "[v4 styles]", "[v4+ styles]":
+      err = reader.readStyleLine(key, value)
+
+    case "[events]":
+      err = reader.readEventLine(key, value)
+    }
+
+    if err != nil {
+      return nil, nil, errors.WithMessage(err, fmt.Sprintf("Invalid line %d", lineNumber))
+    }
+  }
+
+  if err := scanner.Err(); err != nil {
+    return nil, nil, errors.WithMessage(err, "Could not read ass file")
+  }
+
+  var dropped []string
+  for tag := range reader.dropped {
+    dropped = append(dropped, tag)
+  }
+
+  sort.Strings(dropped)
+  return reader.subtitles, dropped, nil
+}
+
+func (reader *assReader) readScriptInfo(key, value string) {
+  switch strings.ToLower(key) {
+  case "scripttype":
+    if strings.EqualFold(value, "v4.00") {
+      reader.legacy = true
+    }
+
+  case "playresx":
+    if size, err := strconv.ParseFloat(value, 64); err == nil && size > 0 {
+      reader.playResX = size
+    }
+
+  case "playresy":
+    if size, err := strconv.ParseFloat(value, 64); err == nil && size > 0 {
+      reader.playResY = size
+    }
+  }
+}
+
+func (reader *assReader) readStyleLine(key, value string) error {
+  switch key {
+  case "Format":
+    reader.styleFormat = splitAssFormat(value)
+
+  case "Style":
+    fields, err := splitAssFields(reader.styleFormat, value)
+    if err != nil {
+      return err
+    }
+
+    style := assStyle{Color: DefaultColor, Alignment: 2}
+    if color, ok := parseAssColor(fields["primarycolour"]); ok {
+      style.Color = color
+    }
+
+    if alignment, err := strconv.Atoi(fields["alignment"]); err == nil {
+      if reader.legacy {
+        alignment = legacyAlignment(alignment)
+      }
+
+      style.Alignment = alignment
+    }
+
+    reader.styles[strings.TrimPrefix(fields["name"], "*")] = style
+  }
+
+  return nil
+}
+
+func (reader *assReader) readEventLine(key, value string) error {
+  switch key {
+  case "Format":
+    reader.eventFormat = splitAssFormat(value)
+
+  case "Dialogue":
+    fields, err := splitAssFields(reader.eventFormat, value)
+    if err != nil {
+      return err
+    }
+
+    start, err := parseAssTime(fields["start"])
+    if err != nil {
+      return err
+    }
+
+    end, err := parseAssTime(fields["end"])
+    if err != nil {
+      return err
+    }
+
+    if end < start {
+      return errors.New("Dialogue ends before it starts")
+    }
+
+    style, ok := reader.styles[strings.TrimPrefix(fields["style"], "*")]
+    if !ok {
+      style = reader.styles["Default"]
+    }
+
+    subtitle := newSubtitle(start, end - start, "")
+    if style.Color != "" {
+      subtitle.Color = style.Color
+    }
+
+    applyAlignment(&subtitle, style.Alignment)
+
+    if reader.readText(&subtitle, fields["text"]) {
+      reader.subtitles = append(reader.subtitles, subtitle)
+    }
+  }
+
+  return nil
+}
+
+// Applies the override tags to the subtitle and sets its text. Returns false
+// if the dialogue does not contain any text that can be rendered.
+func (reader *assReader) readText(subtitle *job.Subtitle, text string) bool {
+  // vector drawings can not be rendered, so everything between
+  // a \p tag with a scale above zero and the next \p0 is left out.
+  drawing := false
+
+  var parts []string
+  last := 0
+  for _, location := range reAssOverride.FindAllStringIndex(text, -1) {
+    if !drawing {
+      parts = append(parts, text[last:location[0]])
+    }
+
+    last = location[1]
+
+    for _, tag := range splitAssTags(text[location[0] + 1:location[1] - 1]) {
+      match := reAssTag.FindStringSubmatch(tag)
+      if match == nil {
+        reader.dropped["\\" + tag] = true
+        continue
+      }
+
+      name, param := match[1], strings.TrimSpace(match[2])
+
+      switch name {
+      case "an":
+        alignment, _ := strconv.Atoi(param)
+        if !applyAlignment(subtitle, alignment) {
+          reader.dropped["\\an"] = true
+        }
+
+      case "a":
+        alignment, _ := strconv.Atoi(param)
+        if !applyAlignment(subtitle, legacyAlignment(alignment)) {
+          reader.dropped["\\a"] = true
+        }
+
+      case "c", "1c":
+        if color, ok := parseAssColor(param); ok {
+          subtitle.Color = color
+        } else if param != "" {
+          reader.dropped["\\" + name] = true
+        }
+
+      case "pos":
+        if !reader.applyPos(subtitle, param) {
+          reader.dropped["\\pos"] = true
+        }
+
+      case "p":
+        drawing = param != "" && param != "0"
+        reader.dropped["\\p"] = true
+
+      default:
+        reader.dropped["\\" + name] = true
+      }
+    }
+  }
+
+  if !drawing {
+    parts = append(parts, text[last:])
+  }
+
+  text = strings.Join(parts, "")
+  text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+  subtitle.Text = strings.TrimSpace(text)
+
+  return subtitle.Text != ""
+}
+
+// Maps a \pos(x,y) tag onto the nearest position.
+func (reader *assReader) applyPos(subtitle *job.Subtitle, param string) bool {
+  coordinates := strings.Split(strings.Trim(param, "()"), ",")
+  if len(coordinates) != 2 {
+    return false
+  }
+
+  x, errX := strconv.ParseFloat(strings.TrimSpace(coordinates[0]), 64)
+  y, errY := strconv.ParseFloat(strings.TrimSpace(coordinates[1]), 64)
+  if errX != nil || errY != nil {
+    return false
+  }
+
+  subtitle.Position.X = thirdOf(x / reader.playResX, [3]string{"left", "center", "right"})
+  subtitle.Position.Y = thirdOf(y / reader.playResY, [3]string{"top", "center", "bottom"})
+  return true
+}
+
+// Splits the content of an override block at every backslash
+// that is not part of a parameter list, e.g. "\t(\c&HFF&)".
+func splitAssTags(block string) []string {
+  var tags []string
+
+  depth, start := 0, -1
+  for idx, char := range block {
+    switch {
+    case char == '(':
+      depth++
+
+    case char == ')' && depth > 0:
+      depth--
+
+    case char == '\\' && depth == 0:
+      if start >= 0 && idx > start {
+        tags = append(tags, block[start:idx])
+      }
+
+      start = idx + 1
+    }
+  }
+
+  if start >= 0 && start < len(block) {
+    tags = append(tags, block[start:])
+  }
+
+  return tags
+}
+
+func splitAssFormat(value string) []string {
+  var names []string
+  for _, name := range strings.Split(value, ",") {
+    names = append(names, strings.ToLower(strings.TrimSpace(name)))
+  }
+
+  return names
+}
+
+// Splits the value of a line into the fields given by the format. The
+// last field, usually the text, may contain commas.
+func splitAssFields(format []string, value string) (map[string]string, error) {
+  if len(format) == 0 {
+    return nil, errors.New("Missing Format line")
+  }
+
+  values := strings.SplitN(value, ",", len(format))
+  if len(values) != len(format) {
+    return nil, fmt.Errorf("Expected %d fields, got %d", len(format), len(values))
+  }
+
+  fields := make(map[string]string, len(format))
+  for idx, name := range format {
+    if idx == len(format) - 1 {
+      fields[name] = values[idx]
+    } else {
+      fields[name] = strings.TrimSpace(values[idx])
+    }
+  }
+
+  return fields, nil
+}
+
+// Parses a time in the format h:mm:ss.cc
+func parseAssTime(value string) (float64, error) {
+  parts := strings.Split(value, ":")
+  if len(parts) != 3 {
+    return 0, fmt.Errorf("Invalid time %q", value)
+  }
+
+  var result float64
+  for _, part := range parts {
+    number, err := strconv.ParseFloat(part, 64)
+    if err != nil {
+      return 0, fmt.Errorf("Invalid time %q", value)
+    }
+
+    result = result * 60 + number
+  }
+
+  return result, nil
+}
+
+// Converts an ass color like &H00BBGGRR& or a decimal ssa color into a hex color.
+func parseAssColor(value string) (string, bool) {
+  var bgr uint64
+  var err error
+
+  if match := reAssColor.FindStringSubmatch(value); match != nil && strings.ContainsAny(value, "&Hh") {
+    bgr, err = strconv.ParseUint(match[1], 16, 32)
+  } else {
+    bgr, err = strconv.ParseUint(value, 10, 32)
+  }
+
+  if err != nil {
+    return "", false
+  }
+
+  return fmt.Sprintf("#%02x%02x%02x", bgr & 0xff, (bgr >> 8) & 0xff, (bgr >> 16) & 0xff), true
+}
+
+// Converts the alignment of ssa files (1-3 bottom, 5-7 top, 9-11 center)
+// to the numpad-style alignment of ass.
+func legacyAlignment(alignment int) int {
+  switch {
+  case alignment >= 1 && alignment <= 3:
+    return alignment
+  case alignment >= 5 && alignment <= 7:
+    return alignment + 2
+  case alignment >= 9 && alignment <= 11:
+    return alignment - 5
+  default:
+    return 0
+  }
+}
@@ -0,0 +1,185 @@
+package subformat
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+const assHeader = "[Script Info]\nScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\n\n" +
+  "[V4+ Styles]\n" +
+  "Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, " +
+  "Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n" +
+  "Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,2,10,10,10,1\n" +
+  "Style: Sign,Arial,20,&H000000FF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,8,10,10,10,1\n\n" +
+  "[Events]\n" +
+  "Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n"
+
+func TestReadASS(t *testing.T) {
+  tests := []struct {
+    name     string
+    events   string
+    expected []job.Subtitle
+    dropped  []string
+  }{
+    {
+      name:   "default style",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,Hello, World\\NSecond\\hline\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1.5, "Hello, World\nSecond line", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:   "style color and alignment",
+      events: "Dialogue: 0,1:02:03.45,1:02:04.00,Sign,,0,0,0,,Sign\n",
+      expected: []job.Subtitle{
+        testSubtitle(3723.45, 0.55, "Sign", "#ff0000", "center", "top"),
+      },
+    },
+    {
+      name:   "unknown style falls back to default",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Missing,,0,0,0,,Hello\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:   "alignment and color overrides",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\an7\\c&H00FF00&}Green\n" +
+        "Dialogue: 0,0:00:03.00,0:00:04.00,Sign,,0,0,0,,{\\1c&HFF8800&\\a2}Blue\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Green", "#00ff00", "left", "top"),
+        testSubtitle(3, 1, "Blue", "#0088ff", "center", "bottom"),
+      },
+    },
+    {
+      name:   "position",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\pos(1800,540)}Right\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Right", DefaultColor, "right", "center"),
+      },
+    },
+    {
+      name: "dropped tags",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,," +
+        "{\\fs20\\bord2\\clip(0,0,10,10)\\t(\\c&HFF&)\\k10\\xyz}Hello{\\fscx120}\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", DefaultColor, "center", "bottom"),
+      },
+      dropped: []string{"\\bord", "\\clip", "\\fs", "\\fscx", "\\k", "\\t", "\\xyz"},
+    },
+    {
+      name:   "clip is not a color",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\clip(0,0,10,10)\\c&H0000FF&}Red\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Red", "#ff0000", "center", "bottom"),
+      },
+      dropped: []string{"\\clip"},
+    },
+    {
+      name:   "invalid values",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\an12\\cxyz\\pos(1)}Hello\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", DefaultColor, "center", "bottom"),
+      },
+      dropped: []string{"\\an", "\\c", "\\pos"},
+    },
+    {
+      name:   "text around drawings",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Before {\\p1}m 0 0 l 100 0 100 100{\\p0}after\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Before after", DefaultColor, "center", "bottom"),
+      },
+      dropped: []string{"\\p"},
+    },
+    {
+      name: "drawings and empty dialogues",
+      events: "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\p1}m 0 0 l 100 0 100 100{\\p0}\n" +
+        "Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,{\\fad(100,100)}\n",
+      dropped: []string{"\\fad", "\\p"},
+    },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      subtitles, dropped, err := ReadASS(strings.NewReader(assHeader + test.events))
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      checkSubtitles(t, subtitles, test.expected)
+
+      if !reflect.DeepEqual(dropped, test.dropped) {
+        t.Errorf("expected dropped tags %q, got %q", test.dropped, dropped)
+      }
+    })
+  }
+}
+
+func TestReadSSA(t *testing.T) {
+  input := "[Script Info]\nScriptType: v4.00\n\n" +
+    "[V4 Styles]\n" +
+    "Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, TertiaryColour, BackColour, Bold, Italic, " +
+    "BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, AlphaLevel, Encoding\n" +
+    "Style: Default,Arial,20,255,65535,0,0,0,0,1,2,2,6,10,10,10,0,0\n\n" +
+    "[Events]\n" +
+    "Format: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+    "Dialogue: Marked=0,0:00:01.00,0:00:02.00,*Default,,0,0,0,,Hello\n" +
+    "Dialogue: Marked=0,0:00:03.00,0:00:04.00,Default,,0,0,0,,{\\a9}Left\n"
+
+  subtitles, dropped, err := ReadASS(strings.NewReader(input))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  checkSubtitles(t, subtitles, []job.Subtitle{
+    testSubtitle(1, 1, "Hello", "#ff0000", "center", "top"),
+    testSubtitle(3, 1, "Left", "#ff0000", "left", "center"),
+  })
+
+  if len(dropped) != 0 {
+    t.Errorf("expected no dropped tags, got %q", dropped)
+  }
+}
+
+func TestReadASSErrors(t *testing.T) {
+  inputs := map[string]string{
+    "missing format":   "[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Hello\n",
+    "invalid time":     assHeader + "Dialogue: 0,0:01.00,0:00:02.00,Default,,0,0,0,,Hello\n",
+    "missing fields":   assHeader + "Dialogue: 0,0:00:01.00,0:00:02.00\n",
+    "end before start": assHeader + "Dialogue: 0,0:00:02.00,0:00:01.00,Default,,0,0,0,,Hello\n",
+  }
+
+  for name, input := range inputs {
+    if _, _, err := ReadASS(strings.NewReader(input)); err == nil {
+      t.Errorf("%s: expected an error", name)
+    }
+  }
+}
+
+func TestParseAssColor(t *testing.T) {
+  tests := []struct {
+    value    string
+    expected string
+    ok       bool
+  }{
+    {"&H000000FF", "#ff0000", true},
+    {"&H00FF8800&", "#0088ff", true},
+    {"&HFF00FF00", "#00ff00", true},
+    {"&hff&", "#ff0000", true},
+    {"H0000FF", "#ff0000", true},
+    {"255", "#ff0000", true},
+    {"16711680", "#0000ff", true},
+    {"", "", false},
+    {"&Hxyz&", "", false},
+  }
+
+  for _, test := range tests {
+    color, ok := parseAssColor(test.value)
+    if color != test.expected || ok != test.ok {
+      t.Errorf("parseAssColor(%q): expected %q %v, got %q %v", test.value, test.expected, test.ok, color, ok)
+    }
+  }
+}
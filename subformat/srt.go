@@ -0,0 +1,167 @@
+package subformat
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+var reSrtTiming = regexp.MustCompile(`^\s*(\d+):(\d\d):(\d\d)[,.](\d{1,3})\s*-->\s*(\d+):(\d\d):(\d\d)[,.](\d{1,3})`)
+var reSrtAlignment = regexp.MustCompile(`\{\\an(\d)\}`)
+var reSrtFontColor = regexp.MustCompile(`(?i)<font[^>]*color\s*=\s*"?(#[0-9a-f]{6})"?[^>]*>`)
+var reSrtTag = regexp.MustCompile(`</?[a-zA-Z][^>]*>|\{\\[^}]*\}`)
+
+// Reads subtitles from a SubRip (.srt) file.
+func ReadSRT(r io.Reader) ([]job.Subtitle, error) {
+  var subtitles []job.Subtitle
+
+  var current *job.Subtitle
+  var lines []string
+
+  flush := func() {
+    if current != nil {
+      current.Text = strings.Join(lines, "\n")
+      subtitles = append(subtitles, *current)
+    }
+
+    current = nil
+    lines = nil
+  }
+
+  scanner := bufio.NewScanner(r)
+  for lineNumber := 1; scanner.Scan(); lineNumber++ {
+    line := strings.TrimRight(scanner.Text(), "\r")
+    if lineNumber == 1 {
+      line = strings.TrimPrefix(line, "\ufeff")
+    }
+
+    if match := reSrtTiming.FindStringSubmatch(line); match != nil {
+      // a timing line always starts a new cue. Drop the index line
+      // we might have collected as text of the previous cue.
+      if current != nil && len(lines) > 0 {
+        if _, err := strconv.Atoi(strings.TrimSpace(lines[len(lines) - 1])); err == nil {
+          lines = lines[:len(lines) - 1]
+        }
+      }
+
+      flush()
+
+      start := parseSrtTime(match[1:5])
+      end := parseSrtTime(match[5:9])
+      if end < start {
+        return nil, fmt.Errorf("Cue in line %d ends before it starts", lineNumber)
+      }
+
+      subtitle := newSubtitle(start, end - start, "")
+      current = &subtitle
+      continue
+    }
+
+    if current == nil {
+      // everything before the first timing line is the cue index
+      continue
+    }
+
+    if strings.TrimSpace(line) == "" {
+      if len(lines) > 0 {
+        flush()
+      }
+
+      continue
+    }
+
+    lines = append(lines, parseSrtText(current, line))
+  }
+
+  if err := scanner.Err(); err != nil {
+    return nil, errors.WithMessage(err, "Could not read srt file")
+  }
+
+  flush()
+  return subtitles, nil
+}
+
+func parseSrtTime(match []string) float64 {
+  hours, _ := strconv.Atoi(match[0])
+  minutes, _ := strconv.Atoi(match[1])
+  seconds, _ := strconv.Atoi(match[2])
+  millis, _ := strconv.Atoi((match[3] + "00")[:3])
+
+  return float64((hours * 60 + minutes) * 60 + seconds) + float64(millis) / 1000
+}
+
+// Extracts position and color from a line of text and
+// returns the line without any formatting tags.
+func parseSrtText(subtitle *job.Subtitle, line string) string {
+  if match := reSrtAlignment.FindStringSubmatch(line); match != nil {
+    alignment, _ := strconv.Atoi(match[1])
+    applyAlignment(subtitle, alignment)
+  }
+
+  if match := reSrtFontColor.FindStringSubmatch(line); match != nil {
+    subtitle.Color = strings.ToLower(match[1])
+  }
+
+  return reSrtTag.ReplaceAllString(line, "")
+}
+
+func init() {
+  // the export job writes the soft subtitle track as srt
+  job.WriteSubtitleTrack = writePlainSRT
+}
+
+// Writes the subtitles of the project as a SubRip (.srt) file. Positions are
+// written as {\anN} tags and colors as <font> tags, if they differ from the defaults.
+func WriteSRT(w io.Writer, project job.Project) error {
+  return writeSRT(w, project.Subtitles, func(subtitle job.Subtitle) string {
+    text := cueText(subtitle.Text)
+    if text == "" {
+      return ""
+    }
+
+    if isCustomColor(subtitle.Color) {
+      text = fmt.Sprintf(`<font color="%s">%s</font>`, subtitle.Color, text)
+    }
+
+    if alignment := alignmentOf(subtitle); alignment != 2 {
+      text = fmt.Sprintf(`{\an%d}%s`, alignment, text)
+    }
+
+    return text
+  })
+}
+
+// Writes the subtitles without colors or positions, mov_text players ignore them anyway.
+func writePlainSRT(w io.Writer, subtitles []job.Subtitle) error {
+  return writeSRT(w, subtitles, func(subtitle job.Subtitle) string {
+    return cueText(subtitle.Text)
+  })
+}
+
+// Writes the subtitles ordered by time as a SubRip (.srt) file. The text of each
+// cue is produced by the given function, cues without any text are left out.
+func writeSRT(w io.Writer, subtitles []job.Subtitle, text func(subtitle job.Subtitle) string) error {
+  buf := bufio.NewWriter(w)
+
+  index := 0
+  for _, subtitle := range sortedSubtitles(subtitles) {
+    cue := text(subtitle)
+    if cue == "" {
+      continue
+    }
+
+    index++
+    fmt.Fprintf(buf, "%d\n%s --> %s\n%s\n\n", index,
+      formatTimestamp(subtitle.Time, ","),
+      formatTimestamp(subtitle.Time + subtitle.Duration, ","),
+      cue)
+  }
+
+  return errors.WithMessage(buf.Flush(), "Could not write srt file")
+}
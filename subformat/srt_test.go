@@ -0,0 +1,137 @@
+package subformat
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+func TestReadSRT(t *testing.T) {
+  tests := []struct {
+    name     string
+    input    string
+    expected []job.Subtitle
+  }{
+    {
+      name:  "plain",
+      input: "1\n00:00:01,000 --> 00:00:02,500\nHello\nWorld\n\n2\n00:00:03,000 --> 00:00:04,000\nBye\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1.5, "Hello\nWorld", DefaultColor, "center", "bottom"),
+        testSubtitle(3, 1, "Bye", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:  "bom and crlf",
+      input: "\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n\r\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:  "short millis and dots",
+      input: "1\n01:02:03.5 --> 01:02:04.25\nHello\n",
+      expected: []job.Subtitle{
+        testSubtitle(3723.5, 0.75, "Hello", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:  "missing empty line between cues",
+      input: "1\n00:00:01,000 --> 00:00:02,000\nHello\n2\n00:00:03,000 --> 00:00:04,000\nBye\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", DefaultColor, "center", "bottom"),
+        testSubtitle(3, 1, "Bye", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:  "position and color",
+      input: "1\n00:00:01,000 --> 00:00:02,000\n{\\an9}<font color=\"#FF0000\">Hello</font>\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", "#ff0000", "right", "top"),
+      },
+    },
+    {
+      name:  "other tags",
+      input: "1\n00:00:01,000 --> 00:00:02,000\n<i>Hello</i> <b>World</b>\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello World", DefaultColor, "center", "bottom"),
+      },
+    },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      subtitles, err := ReadSRT(strings.NewReader(test.input))
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      checkSubtitles(t, subtitles, test.expected)
+    })
+  }
+}
+
+func TestReadSRTEndBeforeStart(t *testing.T) {
+  _, err := ReadSRT(strings.NewReader("1\n00:00:02,000 --> 00:00:01,000\nHello\n"))
+  if err == nil {
+    t.Fatal("expected an error for a cue that ends before it starts")
+  }
+}
+
+func TestWriteSRT(t *testing.T) {
+  project := job.Project{
+    Subtitles: []job.Subtitle{
+      testSubtitle(3, 1, "Second", "#00ff00", "left", "center"),
+      testSubtitle(1, 1.5, "First\n\nLine", DefaultColor, "center", "bottom"),
+      testSubtitle(5, 1, "  ", DefaultColor, "center", "bottom"),
+    },
+  }
+
+  var buf bytes.Buffer
+  if err := WriteSRT(&buf, project); err != nil {
+    t.Fatal(err)
+  }
+
+  expected := "1\n00:00:01,000 --> 00:00:02,500\nFirst\nLine\n\n" +
+    "2\n00:00:03,000 --> 00:00:04,000\n{\\an4}<font color=\"#00ff00\">Second</font>\n\n"
+
+  if buf.String() != expected {
+    t.Errorf("expected %q, got %q", expected, buf.String())
+  }
+}
+
+func TestSRTRoundTrip(t *testing.T) {
+  subtitles := []job.Subtitle{
+    testSubtitle(0, 0.5, "Hello", DefaultColor, "center", "bottom"),
+    testSubtitle(1.25, 2, "Two\nLines", "#ff8800", "right", "top"),
+    testSubtitle(3725.125, 1, "Late", "#0000ff", "left", "center"),
+  }
+
+  var buf bytes.Buffer
+  if err := WriteSRT(&buf, job.Project{Subtitles: subtitles}); err != nil {
+    t.Fatal(err)
+  }
+
+  actual, err := ReadSRT(&buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  checkSubtitles(t, actual, subtitles)
+}
+
+func TestWritePlainSRT(t *testing.T) {
+  subtitles := []job.Subtitle{
+    testSubtitle(1, 1, "Hello", "#ff0000", "right", "top"),
+  }
+
+  var buf bytes.Buffer
+  if err := writePlainSRT(&buf, subtitles); err != nil {
+    t.Fatal(err)
+  }
+
+  if expected := "1\n00:00:01,000 --> 00:00:02,000\nHello\n\n"; buf.String() != expected {
+    t.Errorf("expected %q, got %q", expected, buf.String())
+  }
+}
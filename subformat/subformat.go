@@ -0,0 +1,161 @@
+// Package subformat converts the subtitles of a job.Project from and to
+// common subtitle file formats.
+package subformat
+
+import (
+  "fmt"
+  "io"
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+const (
+  DefaultColor     = "#ffffff"
+  DefaultPositionX = "center"
+  DefaultPositionY = "bottom"
+)
+
+// Reads subtitles from a file in some subtitle format. Also returns the
+// formatting features of the file that were dropped while reading.
+type Decoder func(r io.Reader) ([]job.Subtitle, []string, error)
+
+// Writes the subtitles of the project in some subtitle format.
+type Encoder func(w io.Writer, project job.Project) error
+
+type Format struct {
+  Name        string
+  ContentType string
+  Extension   string
+
+  Decode      Decoder
+  Encode      Encoder
+}
+
+var reHexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+var reEmptyLines = regexp.MustCompile(`\n\s*\n`)
+
+var formats = map[string]Format{
+  "srt": {
+    Name:        "srt",
+    ContentType: "application/x-subrip",
+    Extension:   ".srt",
+    Decode:      lossless(ReadSRT),
+    Encode:      WriteSRT,
+  },
+  "vtt": {
+    Name:        "vtt",
+    ContentType: "text/vtt; charset=utf-8",
+    Extension:   ".vtt",
+    Decode:      lossless(ReadVTT),
+    Encode:      WriteVTT,
+  },
+  "ass": {
+    Name:        "ass",
+    ContentType: "text/x-ssa; charset=utf-8",
+    Extension:   ".ass",
+    Decode:      ReadASS,
+  },
+  "ssa": {
+    Name:        "ssa",
+    ContentType: "text/x-ssa; charset=utf-8",
+    Extension:   ".ssa",
+    Decode:      ReadASS,
+  },
+}
+
+// Adapts a reader that does not report dropped features to a Decoder.
+func lossless(read func(r io.Reader) ([]job.Subtitle, error)) Decoder {
+  return func(r io.Reader) ([]job.Subtitle, []string, error) {
+    subtitles, err := read(r)
+    return subtitles, nil, err
+  }
+}
+
+// Looks up a format by its name, e.g. "srt".
+func Lookup(name string) (Format, bool) {
+  format, ok := formats[name]
+  return format, ok
+}
+
+// Creates a new subtitle with the default color and position.
+func newSubtitle(time, duration float64, text string) job.Subtitle {
+  subtitle := job.Subtitle{
+    Text:     text,
+    Time:     time,
+    Duration: duration,
+    Color:    DefaultColor,
+  }
+
+  subtitle.Position.X = DefaultPositionX
+  subtitle.Position.Y = DefaultPositionY
+  return subtitle
+}
+
+// Returns a copy of the subtitles ordered by their start time.
+func sortedSubtitles(subtitles []job.Subtitle) []job.Subtitle {
+  result := append([]job.Subtitle(nil), subtitles...)
+  sort.SliceStable(result, func(i, j int) bool {
+    return result[i].Time < result[j].Time
+  })
+
+  return result
+}
+
+// Returns true, if the color is a valid hex color other than the default color.
+func isCustomColor(color string) bool {
+  return reHexColor.MatchString(color) && !strings.EqualFold(color, DefaultColor)
+}
+
+// Prepares the text of a subtitle for writing into a cue. An empty
+// line would end the cue early, so those are removed.
+func cueText(text string) string {
+  text = strings.Replace(text, "\r", "", -1)
+  return strings.TrimSpace(reEmptyLines.ReplaceAllString(text, "\n"))
+}
+
+// Converts a position into the numpad-style alignment used by
+// the {\anN} override tag: 1-3 bottom, 4-6 center, 7-9 top.
+func alignmentOf(subtitle job.Subtitle) int {
+  column := 2
+  switch subtitle.Position.X {
+  case "left":
+    column = 1
+  case "right":
+    column = 3
+  }
+
+  switch subtitle.Position.Y {
+  case "top":
+    return 6 + column
+  case "center":
+    return 3 + column
+  default:
+    return column
+  }
+}
+
+// Applies a numpad-style alignment to the position of the subtitle.
+// Returns false, if the alignment is not valid.
+func applyAlignment(subtitle *job.Subtitle, alignment int) bool {
+  if alignment < 1 || alignment > 9 {
+    return false
+  }
+
+  subtitle.Position.X = [...]string{"left", "center", "right"}[(alignment - 1) % 3]
+  subtitle.Position.Y = [...]string{"bottom", "center", "top"}[(alignment - 1) / 3]
+  return true
+}
+
+// Formats a time in seconds as hh:mm:ss followed by the separator and milliseconds.
+func formatTimestamp(seconds float64, separator string) string {
+  millis := int64(seconds * 1000 + 0.5)
+  if millis < 0 {
+    millis = 0
+  }
+
+  return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+    millis / 3600000, millis / 60000 % 60, millis / 1000 % 60, separator, millis % 1000)
+}
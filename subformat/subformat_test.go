@@ -0,0 +1,76 @@
+package subformat
+
+import (
+  "math"
+  "testing"
+
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+// Creates a subtitle for the expected results of a test.
+func testSubtitle(time, duration float64, text, color, x, y string) job.Subtitle {
+  subtitle := newSubtitle(time, duration, text)
+  subtitle.Color = color
+  subtitle.Position.X = x
+  subtitle.Position.Y = y
+  return subtitle
+}
+
+// Compares the subtitles, allowing for rounding errors of the times.
+func checkSubtitles(t *testing.T, actual, expected []job.Subtitle) {
+  t.Helper()
+
+  if len(actual) != len(expected) {
+    t.Fatalf("expected %d subtitles, got %d: %+v", len(expected), len(actual), actual)
+  }
+
+  for idx := range expected {
+    a, e := actual[idx], expected[idx]
+    if math.Abs(a.Time - e.Time) > 0.0005 || math.Abs(a.Duration - e.Duration) > 0.0005 {
+      t.Errorf("subtitle %d: expected time %g+%g, got %g+%g", idx, e.Time, e.Duration, a.Time, a.Duration)
+    }
+
+    if a.Text != e.Text || a.Color != e.Color || a.Position != e.Position {
+      t.Errorf("subtitle %d: expected %+v, got %+v", idx, e, a)
+    }
+  }
+}
+
+func TestFormatTimestamp(t *testing.T) {
+  tests := []struct {
+    seconds   float64
+    separator string
+    expected  string
+  }{
+    {0, ",", "00:00:00,000"},
+    {1.5, ",", "00:00:01,500"},
+    {61.25, ".", "00:01:01.250"},
+    {3599.9996, ",", "01:00:00,000"},
+    {360000, ".", "100:00:00.000"},
+    {-1, ",", "00:00:00,000"},
+  }
+
+  for _, test := range tests {
+    if actual := formatTimestamp(test.seconds, test.separator); actual != test.expected {
+      t.Errorf("formatTimestamp(%g): expected %q, got %q", test.seconds, test.expected, actual)
+    }
+  }
+}
+
+func TestCueText(t *testing.T) {
+  tests := []struct {
+    text     string
+    expected string
+  }{
+    {"Hello", "Hello"},
+    {"  Hello\r\nWorld  ", "Hello\nWorld"},
+    {"Hello\n\n  \nWorld", "Hello\nWorld"},
+    {"\n\n", ""},
+  }
+
+  for _, test := range tests {
+    if actual := cueText(test.text); actual != test.expected {
+      t.Errorf("cueText(%q): expected %q, got %q", test.text, test.expected, actual)
+    }
+  }
+}
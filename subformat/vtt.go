@@ -0,0 +1,285 @@
+package subformat
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "github.com/mopsalarm/s0btitle/job"
+  "github.com/pkg/errors"
+)
+
+var reVttTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d\d:\d\d\.\d{3})\s+-->\s+((?:\d+:)?\d\d:\d\d\.\d{3})(.*)$`)
+var reVttClass = regexp.MustCompile(`<c((?:\.[\w-]+)+)>`)
+var reVttTag = regexp.MustCompile(`</?[a-zA-Z][^>]*>|<\d[\d:.]*>`)
+var reVttStyleRule = regexp.MustCompile(`::cue\(\.([\w-]+)\)\s*\{[^}]*?color\s*:\s*(#[0-9a-fA-F]{6})`)
+
+// The color classes every WebVTT player knows without a style block.
+var vttDefaultClasses = map[string]string{
+  "white":   "#ffffff",
+  "lime":    "#00ff00",
+  "cyan":    "#00ffff",
+  "red":     "#ff0000",
+  "yellow":  "#ffff00",
+  "magenta": "#ff00ff",
+  "blue":    "#0000ff",
+  "black":   "#000000",
+}
+
+var vttUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "", "&rlm;", "")
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Reads subtitles from a WebVTT file. The cue settings "line", "position" and
+// "align" are mapped onto the position of the subtitle, class spans onto its color.
+func ReadVTT(r io.Reader) ([]job.Subtitle, error) {
+  blocks, err := readBlocks(r)
+  if err != nil {
+    return nil, errors.WithMessage(err, "Could not read vtt file")
+  }
+
+  if len(blocks) == 0 || !strings.HasPrefix(strings.TrimPrefix(blocks[0][0], "\ufeff"), "WEBVTT") {
+    return nil, errors.New("Missing WEBVTT header")
+  }
+
+  classes := make(map[string]string)
+  for name, color := range vttDefaultClasses {
+    classes[name] = color
+  }
+
+  var subtitles []job.Subtitle
+  for _, block := range blocks[1:] {
+    if block[0] == "STYLE" {
+      for _, match := range reVttStyleRule.FindAllStringSubmatch(strings.Join(block[1:], "\n"), -1) {
+        classes[match[1]] = strings.ToLower(match[2])
+      }
+
+      continue
+    }
+
+    // the timing is in the first or, if the cue has an identifier, in the second line
+    for idx, line := range block {
+      if idx > 1 {
+        break
+      }
+
+      match := reVttTiming.FindStringSubmatch(line)
+      if match == nil {
+        continue
+      }
+
+      start, end := parseVttTime(match[1]), parseVttTime(match[2])
+      if end < start {
+        return nil, fmt.Errorf("Cue %q ends before it starts", line)
+      }
+
+      subtitle := newSubtitle(start, end - start, "")
+      applyVttSettings(&subtitle, strings.Fields(match[3]))
+
+      var lines []string
+      for _, text := range block[idx + 1:] {
+        lines = append(lines, parseVttText(&subtitle, classes, text))
+      }
+
+      subtitle.Text = strings.Join(lines, "\n")
+      subtitles = append(subtitles, subtitle)
+      break
+    }
+  }
+
+  return subtitles, nil
+}
+
+// Splits the input into blocks of lines separated by empty lines.
+func readBlocks(r io.Reader) ([][]string, error) {
+  var blocks [][]string
+  var current []string
+
+  scanner := bufio.NewScanner(r)
+  for scanner.Scan() {
+    line := strings.TrimRight(scanner.Text(), "\r")
+    if strings.TrimSpace(line) == "" {
+      if len(current) > 0 {
+        blocks = append(blocks, current)
+        current = nil
+      }
+
+      continue
+    }
+
+    current = append(current, line)
+  }
+
+  if len(current) > 0 {
+    blocks = append(blocks, current)
+  }
+
+  return blocks, scanner.Err()
+}
+
+func parseVttTime(value string) float64 {
+  var result float64
+  for _, part := range strings.Split(value, ":") {
+    number, _ := strconv.ParseFloat(part, 64)
+    result = result * 60 + number
+  }
+
+  return result
+}
+
+// Parses a percentage or a line number of a cue setting into a relative
+// position between 0 and 1. Negative line numbers count from the bottom.
+func parseVttOffset(value string) (float64, bool) {
+  value = strings.SplitN(value, ",", 2)[0]
+
+  if strings.HasSuffix(value, "%") {
+    percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+    return percent / 100, err == nil
+  }
+
+  line, err := strconv.Atoi(value)
+  if err != nil {
+    return 0, false
+  }
+
+  if line < 0 {
+    return 1, true
+  }
+
+  return 0, true
+}
+
+func thirdOf(offset float64, positions [3]string) string {
+  switch {
+  case offset < 1.0 / 3:
+    return positions[0]
+  case offset > 2.0 / 3:
+    return positions[2]
+  default:
+    return positions[1]
+  }
+}
+
+func applyVttSettings(subtitle *job.Subtitle, settings []string) {
+  alignSet := false
+
+  for _, setting := range settings {
+    parts := strings.SplitN(setting, ":", 2)
+    if len(parts) != 2 {
+      continue
+    }
+
+    switch parts[0] {
+    case "line":
+      if offset, ok := parseVttOffset(parts[1]); ok {
+        subtitle.Position.Y = thirdOf(offset, [3]string{"top", "center", "bottom"})
+      }
+
+    case "position":
+      if offset, ok := parseVttOffset(parts[1]); ok && !alignSet {
+        subtitle.Position.X = thirdOf(offset, [3]string{"left", "center", "right"})
+      }
+
+    case "align":
+      switch parts[1] {
+      case "start", "left":
+        subtitle.Position.X = "left"
+      case "end", "right":
+        subtitle.Position.X = "right"
+      default:
+        subtitle.Position.X = "center"
+      }
+
+      alignSet = true
+    }
+  }
+}
+
+// Extracts the color from the class spans of a line of text and
+// returns the line without any markup.
+func parseVttText(subtitle *job.Subtitle, classes map[string]string, line string) string {
+  for _, match := range reVttClass.FindAllStringSubmatch(line, -1) {
+    for _, class := range strings.Split(match[1][1:], ".") {
+      if color, ok := classes[class]; ok {
+        subtitle.Color = color
+      }
+    }
+  }
+
+  return vttUnescaper.Replace(reVttTag.ReplaceAllString(line, ""))
+}
+
+// Returns the css class to use for a color.
+func vttClassOf(color string) string {
+  color = strings.ToLower(color)
+  for name, value := range vttDefaultClasses {
+    if value == color {
+      return name
+    }
+  }
+
+  return "color-" + strings.TrimPrefix(color, "#")
+}
+
+// Writes the subtitles of the project as a WebVTT file. Colors that have no
+// predefined class are declared in a STYLE block.
+func WriteVTT(w io.Writer, project job.Project) error {
+  buf := bufio.NewWriter(w)
+  buf.WriteString("WEBVTT\n\n")
+
+  subtitles := sortedSubtitles(project.Subtitles)
+
+  // declare styles for all custom colors
+  declared := make(map[string]bool)
+  for _, subtitle := range subtitles {
+    if !isCustomColor(subtitle.Color) {
+      continue
+    }
+
+    class := vttClassOf(subtitle.Color)
+    if _, predefined := vttDefaultClasses[class]; predefined || declared[class] {
+      continue
+    }
+
+    declared[class] = true
+    fmt.Fprintf(buf, "STYLE\n::cue(.%s) {\n  color: %s;\n}\n\n", class, strings.ToLower(subtitle.Color))
+  }
+
+  for idx, subtitle := range subtitles {
+    var settings []string
+
+    switch subtitle.Position.Y {
+    case "top":
+      settings = append(settings, "line:10%")
+    case "center":
+      settings = append(settings, "line:50%")
+    }
+
+    switch subtitle.Position.X {
+    case "left":
+      settings = append(settings, "align:left")
+    case "right":
+      settings = append(settings, "align:right")
+    }
+
+    text := vttEscaper.Replace(cueText(subtitle.Text))
+
+    if isCustomColor(subtitle.Color) {
+      text = fmt.Sprintf("<c.%s>%s</c>", vttClassOf(subtitle.Color), text)
+    }
+
+    fmt.Fprintf(buf, "%d\n%s --> %s", idx + 1,
+      formatTimestamp(subtitle.Time, "."),
+      formatTimestamp(subtitle.Time + subtitle.Duration, "."))
+
+    if len(settings) > 0 {
+      buf.WriteString(" " + strings.Join(settings, " "))
+    }
+
+    fmt.Fprintf(buf, "\n%s\n\n", text)
+  }
+
+  return errors.WithMessage(buf.Flush(), "Could not write vtt file")
+}
@@ -0,0 +1,139 @@
+package subformat
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+
+  "github.com/mopsalarm/s0btitle/job"
+)
+
+func TestReadVTT(t *testing.T) {
+  tests := []struct {
+    name     string
+    input    string
+    expected []job.Subtitle
+  }{
+    {
+      name:  "plain",
+      input: "WEBVTT\n\n00:01.000 --> 00:02.500\nHello\nWorld\n\nsecond\n01:00:03.000 --> 01:00:04.000\nBye\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1.5, "Hello\nWorld", DefaultColor, "center", "bottom"),
+        testSubtitle(3603, 1, "Bye", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:  "bom, crlf and header text",
+      input: "\ufeffWEBVTT - some title\r\n\r\n00:00:01.000 --> 00:00:02.000\r\nHello\r\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", DefaultColor, "center", "bottom"),
+      },
+    },
+    {
+      name:  "cue settings",
+      input: "WEBVTT\n\n" +
+        "00:00:01.000 --> 00:00:02.000 line:10% align:left\nTop left\n\n" +
+        "00:00:03.000 --> 00:00:04.000 line:50% position:90%\nCenter right\n\n" +
+        "00:00:05.000 --> 00:00:06.000 line:0\nFirst line\n\n" +
+        "00:00:07.000 --> 00:00:08.000 line:-1 align:end position:10%\nLast line\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Top left", DefaultColor, "left", "top"),
+        testSubtitle(3, 1, "Center right", DefaultColor, "right", "center"),
+        testSubtitle(5, 1, "First line", DefaultColor, "center", "top"),
+        testSubtitle(7, 1, "Last line", DefaultColor, "right", "bottom"),
+      },
+    },
+    {
+      name:  "default classes",
+      input: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<c.yellow>Hello</c> <b>World</b>\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello World", "#ffff00", "center", "bottom"),
+      },
+    },
+    {
+      name:  "classes from style block",
+      input: "WEBVTT\n\nSTYLE\n::cue(.orange) {\n  color: #FF8800;\n}\n\n" +
+        "00:00:01.000 --> 00:00:02.000\n<c.loud.orange>Hello</c>\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Hello", "#ff8800", "center", "bottom"),
+      },
+    },
+    {
+      name:  "unknown class and escapes",
+      input: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<c.unknown>Tom &amp; Jerry &lt;3</c><00:00:01.500>\n",
+      expected: []job.Subtitle{
+        testSubtitle(1, 1, "Tom & Jerry <3", DefaultColor, "center", "bottom"),
+      },
+    },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      subtitles, err := ReadVTT(strings.NewReader(test.input))
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      checkSubtitles(t, subtitles, test.expected)
+    })
+  }
+}
+
+func TestReadVTTErrors(t *testing.T) {
+  inputs := map[string]string{
+    "missing header":   "00:00:01.000 --> 00:00:02.000\nHello\n",
+    "empty file":       "",
+    "end before start": "WEBVTT\n\n00:00:02.000 --> 00:00:01.000\nHello\n",
+  }
+
+  for name, input := range inputs {
+    if _, err := ReadVTT(strings.NewReader(input)); err == nil {
+      t.Errorf("%s: expected an error", name)
+    }
+  }
+}
+
+func TestWriteVTT(t *testing.T) {
+  project := job.Project{
+    Subtitles: []job.Subtitle{
+      testSubtitle(3, 1, "Orange", "#FF8800", "right", "center"),
+      testSubtitle(1, 1.5, "Red & <b>", "#ff0000", "left", "top"),
+      testSubtitle(5, 1, "Plain", DefaultColor, "center", "bottom"),
+    },
+  }
+
+  var buf bytes.Buffer
+  if err := WriteVTT(&buf, project); err != nil {
+    t.Fatal(err)
+  }
+
+  expected := "WEBVTT\n\n" +
+    "STYLE\n::cue(.color-ff8800) {\n  color: #ff8800;\n}\n\n" +
+    "1\n00:00:01.000 --> 00:00:02.500 line:10% align:left\n<c.red>Red &amp; &lt;b&gt;</c>\n\n" +
+    "2\n00:00:03.000 --> 00:00:04.000 line:50% align:right\n<c.color-ff8800>Orange</c>\n\n" +
+    "3\n00:00:05.000 --> 00:00:06.000\nPlain\n\n"
+
+  if buf.String() != expected {
+    t.Errorf("expected %q, got %q", expected, buf.String())
+  }
+}
+
+func TestVTTRoundTrip(t *testing.T) {
+  subtitles := []job.Subtitle{
+    testSubtitle(0, 0.5, "Hello", DefaultColor, "center", "bottom"),
+    testSubtitle(1.25, 2, "Two\nLines & more", "#ff8800", "right", "top"),
+    testSubtitle(3725.125, 1, "Cyan", "#00ffff", "left", "center"),
+  }
+
+  var buf bytes.Buffer
+  if err := WriteVTT(&buf, job.Project{Subtitles: subtitles}); err != nil {
+    t.Fatal(err)
+  }
+
+  actual, err := ReadVTT(&buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  checkSubtitles(t, actual, subtitles)
+}